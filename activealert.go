@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 条目当前处于告警或抖动中的状态
+type ActiveAlert struct {
+	// 接口命名
+	InterfaceName string `json:"interfaceName"`
+	// 告警类型
+	AlertType AlertType `json:"alertType"`
+	// 告警级别，只在抖动而当前未处于告警中时为NORMAL
+	Severity Severity `json:"severity"`
+	// 告警开始时间
+	StartsAt time.Time `json:"startsAt"`
+	// 是否已经发出通知
+	Notified bool `json:"notified"`
+	// 是否已经升级
+	Escalated bool `json:"escalated"`
+	// 是否处于抖动中
+	Flapping bool `json:"flapping"`
+}
+
+// 各条目当前的告警状态，由告警分析模块在每次分析之后更新，供使用方查询
+type activeAlerts struct {
+	lock sync.Mutex
+	alerts map[string][]ActiveAlert
+}
+
+// 更新条目当前的告警状态，只在告警分析模块中调用
+func (c *ReportClientConfig) publishAlerts(entryName string) {
+	var alerts []ActiveAlert
+	for alertType, statusMap := range c.alertStatusMap {
+		status, ok := statusMap[entryName]
+		if !ok || (status.curState == NONE && !status.flapping) {
+			continue
+		}
+		alert := ActiveAlert {
+			InterfaceName: entryName,
+			AlertType: alertType,
+			Flapping: status.flapping,
+		}
+		if status.curState != NONE {
+			alert.Severity = status.severity
+			alert.StartsAt = status.startsAt
+			alert.Notified = status.notified
+			alert.Escalated = status.escalated
+		}
+		alerts = append(alerts, alert)
+	}
+	c.activeAlerts.lock.Lock()
+	defer c.activeAlerts.lock.Unlock()
+	if len(alerts) == 0 {
+		delete(c.activeAlerts.alerts, entryName)
+	} else {
+		c.activeAlerts.alerts[entryName] = alerts
+	}
+}
+
+// 当前处于告警或抖动中的全部告警，按条目名称和告警类型排列
+func (c *ReportClientConfig) Alerts() []ActiveAlert {
+	c.activeAlerts.lock.Lock()
+	alerts := []ActiveAlert {}
+	for _, entryAlerts := range c.activeAlerts.alerts {
+		alerts = append(alerts, entryAlerts...)
+	}
+	c.activeAlerts.lock.Unlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].InterfaceName != alerts[j].InterfaceName {
+			return alerts[i].InterfaceName < alerts[j].InterfaceName
+		}
+		return alerts[i].AlertType < alerts[j].AlertType
+	})
+	return alerts
+}
@@ -4,48 +4,279 @@ import (
 	"strconv"
 	"os"
 	"bytes"
+	"sync"
+	"time"
 )
 
+// 告警事件，描述一次告警状态的变化，或告警持续期间的重复与升级通知
+type AlertEvent struct {
+	// 客户端命名
+	ClientName string `json:"clientName"`
+	// 接口命名
+	InterfaceName string `json:"interfaceName"`
+	// 客户端的标签
+	Labels map[string]string `json:"labels,omitempty"`
+	// 告警类型
+	AlertType AlertType `json:"alertType"`
+	// 事件类型
+	EventType EventType `json:"eventType"`
+	// 告警开始时间，即第一个不达标周期的时间
+	StartsAt time.Time `json:"startsAt"`
+	// 事件发生时间
+	Time time.Time `json:"time"`
+	// 告警已经持续的时间
+	Duration time.Duration `json:"duration"`
+	// 告警级别
+	Severity Severity `json:"severity"`
+	// 级别变化之前的级别，仅在级别变化事件与恢复事件中有值，恢复事件的Severity为NORMAL，PrevSeverity为恢复之前的级别
+	PrevSeverity Severity `json:"prevSeverity,omitempty"`
+	// 告警是否已经升级
+	Escalated bool `json:"escalated"`
+	// 相关的统计数据：告警时为连续不达标的数据，恢复时为连续达标的数据，重复与升级通知时为当前周期的数据
+	RecentOutputData []OutPutData `json:"recentOutputData"`
+}
+
+// 通知队列，长度不设上限，告警分析放入事件后立即返回，通知模块按放入的顺序取出
+// 告警、恢复等状态变化的事件一旦产生，告警状态即已更新，丢弃将导致告警或恢复永远不被通知，因此不能使用有界的通道
+type eventQueue struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	events []*AlertEvent
+	closed bool
+	// 已放入但尚未送达的事件
+	pending sync.WaitGroup
+}
+
+// 创建通知队列
+func newEventQueue() *eventQueue {
+	q := &eventQueue {}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// 放入事件
+func (q *eventQueue) push(e *AlertEvent) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending.Add(1)
+	q.events = append(q.events, e)
+	q.cond.Signal()
+}
+
+// 取出最早的事件，队列为空时等待，队列关闭且为空时返回false
+func (q *eventQueue) pop() (*AlertEvent, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.events) == 0 {
+		return nil, false
+	}
+	e := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+	return e, true
+}
+
+// 关闭队列，已放入的事件仍会被取出
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// 将告警事件交给通知模块，告警分析不等待回调执行，回调阻塞时事件在队列中等待，不会阻塞告警分析进而阻塞上报
+func (c *ReportClientConfig) dispatch(e *AlertEvent) {
+	c.eventQueue.push(e)
+}
+
+// 通知模块，按告警事件产生的顺序串行调用各个回调
+func (c *ReportClientConfig) notify() {
+	for {
+		e, ok := c.eventQueue.pop()
+		if !ok {
+			return
+		}
+		c.deliver(e)
+		c.eventQueue.pending.Done()
+	}
+}
+
+// 分发告警事件：告警通知交给AlertCaller，重复通知交给RepeatCaller，升级通知交给EscalationCaller，恢复通知交给RecoverCaller，抖动通知交给FlappingCaller
+// 重复通知不交给AlertCaller，以免原有的告警处理把它当作新的告警
+// EventCaller接收全部事件，SeverityCallers则按事件的告警级别接收对应的事件，告警管理器再按路由规则分发给接收者
+func (c *ReportClientConfig) deliver(e *AlertEvent) {
+	if c.EventCaller != nil {
+		c.EventCaller(e)
+	}
+	if caller, ok := c.SeverityCallers[e.Severity]; ok {
+		caller(e)
+	}
+	if c.AlertManager != nil {
+		c.AlertManager.dispatch(e)
+	}
+	// 配置了告警管理器时，由告警管理器负责通知，不再默认输出到控制台
+	printDefault := c.AlertManager == nil
+	switch e.EventType {
+	case SEVERITY_CHANGED:
+		// 级别变化没有对应的旧式回调，未定制告警处理时输出到控制台
+		if c.AlertCaller == nil && printDefault {
+			defaultRepeat(e)
+		}
+	case FIRING:
+		if c.AlertCaller != nil {
+			c.AlertCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		} else if printDefault {
+			defaultAlert(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		}
+	case REPEAT:
+		if c.RepeatCaller != nil {
+			c.RepeatCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		} else if c.AlertCaller == nil && printDefault {
+			// 未定制告警处理时输出到控制台
+			defaultRepeat(e)
+		}
+		// 已经升级的告警，重复通知同样发给升级对象
+		if c.EscalationCaller != nil && e.Escalated {
+			c.EscalationCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		}
+	case ESCALATE:
+		if c.EscalationCaller != nil {
+			c.EscalationCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		} else if printDefault {
+			defaultRepeat(e)
+		}
+	case RESOLVED:
+		if c.RecoverCaller != nil {
+			c.RecoverCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		} else if printDefault {
+			defaultRecover(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		}
+	case FLAPPING:
+		if c.FlappingCaller != nil {
+			c.FlappingCaller(e.ClientName, e.InterfaceName, e.AlertType, e.RecentOutputData)
+		} else if printDefault {
+			defaultRepeat(e)
+		}
+	}
+}
+
 // 默认告警处理方式
 func defaultAlert(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
-	alertTypeString := "未知"
-	if alertType == SLOW {
-		alertTypeString = "时延达标率"
-	} else if alertType == FAIL {
-		alertTypeString = "访问成功率"
-	}
+	alertTypeString := alertTypeName(alertType)
 	var alertString bytes.Buffer
 	alertString.WriteString("\n 告警：\n   客户端上报类型：" + clientName + "\n   接口：" + interfaceName + "\n   告警类型：" + alertTypeString + "\n   最近" + strconv.Itoa(len(recentOutputData)) + "状态：")
-	for i, r := range recentOutputData {
-		var rate float64
-		if alertType == SLOW {
-			rate = r.FastRate
-		} else if alertType == FAIL {
-			rate = r.SuccessRate
-		}
-		alertString.WriteString("\n     " + strconv.Itoa(i + 1) + ". " + "调用" + strconv.FormatUint(uint64(r.Count), 10) + "次，" + alertTypeString + "为" + strconv.FormatFloat(float64(rate * 100), 'f', 2, 64) + "%")
-	}
+	writeRecentOutputData(&alertString, alertType, recentOutputData)
 	os.Stderr.WriteString(alertString.String() + "\n")
 }
 
 // 默认恢复通知处理方式
 func defaultRecover(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
-	alertTypeString := "未知"
-	if alertType == SLOW {
-		alertTypeString = "时延达标率"
-	} else if alertType == FAIL {
-		alertTypeString = "访问成功率"
-	}
+	alertTypeString := alertTypeName(alertType)
 	var alertString bytes.Buffer
 	alertString.WriteString("\n 恢复通知：\n   客户端上报类型：" + clientName + "\n   接口：" + interfaceName + "\n   恢复类型：" + alertTypeString + "\n   最近" + strconv.Itoa(len(recentOutputData)) + "状态：")
+	writeRecentOutputData(&alertString, alertType, recentOutputData)
+	os.Stderr.WriteString(alertString.String() + "\n")
+}
+
+// 默认重复、升级、级别变化与抖动通知处理方式
+func defaultRepeat(e *AlertEvent) {
+	title := "重复告警"
+	if e.EventType == ESCALATE {
+		title = "告警升级"
+	} else if e.EventType == FLAPPING {
+		title = "告警抖动"
+	} else if e.EventType == SEVERITY_CHANGED {
+		title = "告警级别由" + severityName(e.PrevSeverity) + "变为" + severityName(e.Severity)
+	}
+	alertTypeString := alertTypeName(e.AlertType)
+	var alertString bytes.Buffer
+	alertString.WriteString("\n " + title + "：\n   客户端上报类型：" + e.ClientName + "\n   接口：" + e.InterfaceName + "\n   告警类型：" + alertTypeString + "\n   已持续：" + e.Duration.String() + "\n   当前状态：")
+	writeRecentOutputData(&alertString, e.AlertType, e.RecentOutputData)
+	os.Stderr.WriteString(alertString.String() + "\n")
+}
+
+// 告警类型的可读名称
+func alertTypeName(alertType AlertType) string {
+	switch alertType {
+	case SLOW:
+		return "时延达标率"
+	case FAIL:
+		return "访问成功率"
+	case FAIL_BUDGET:
+		return "可用性错误预算"
+	case SLOW_BUDGET:
+		return "时延错误预算"
+	case NO_DATA:
+		return "无数据"
+	case TRAFFIC_DROP:
+		return "调用量"
+	case ANOMALY:
+		return "异常检测"
+	}
+	return "未知"
+}
+
+// 告警级别的可读名称
+func severityName(severity Severity) string {
+	switch severity {
+	case WARNING:
+		return "警告"
+	case CRITICAL:
+		return "严重"
+	}
+	return "正常"
+}
+
+// 逐条写入最近几次的统计数据
+func writeRecentOutputData(alertString *bytes.Buffer, alertType AlertType, recentOutputData []OutPutData) {
+	alertTypeString := alertTypeName(alertType)
 	for i, r := range recentOutputData {
+		alertString.WriteString("\n     " + strconv.Itoa(i + 1) + ". " + "调用" + strconv.FormatUint(uint64(r.Count), 10) + "次")
+		if alertType == FAIL_BUDGET || alertType == SLOW_BUDGET {
+			var budget *BudgetStatus
+			if r.ErrorBudget != nil && alertType == FAIL_BUDGET {
+				budget = r.ErrorBudget.Availability
+			} else if r.ErrorBudget != nil {
+				budget = r.ErrorBudget.Latency
+			}
+			if budget == nil {
+				continue
+			}
+			alertString.WriteString("，" + alertTypeString + "剩余" + strconv.FormatFloat(budget.Remaining * 100, 'f', 2, 64) + "%")
+			for _, b := range budget.BurnRates {
+				if b.Firing {
+					alertString.WriteString("，燃烧率" + b.LongWindow + "/" + b.ShortWindow + "为" + strconv.FormatFloat(b.LongBurnRate, 'f', 2, 64) + "/" + strconv.FormatFloat(b.ShortBurnRate, 'f', 2, 64) + "（阈值" + strconv.FormatFloat(b.Threshold, 'f', 2, 64) + "）")
+				}
+			}
+			continue
+		}
+		if alertType == NO_DATA {
+			continue
+		}
+		if alertType == TRAFFIC_DROP {
+			alertString.WriteString("，" + alertTypeString + "基线为" + strconv.FormatFloat(r.TrafficBaseline, 'f', 2, 64) + "次")
+			continue
+		}
+		if alertType == ANOMALY {
+			if r.Anomaly == nil {
+				continue
+			}
+			for _, m := range r.Anomaly.Metrics {
+				if m.Anomalous {
+					alertString.WriteString("，" + m.Name + "为" + strconv.FormatFloat(m.Value, 'f', 4, 64) + "，基线为" + strconv.FormatFloat(m.Mean, 'f', 4, 64) + "，偏离" + strconv.FormatFloat(m.Deviation, 'f', 2, 64) + "个标准差")
+				}
+			}
+			continue
+		}
 		var rate float64
 		if alertType == SLOW {
 			rate = r.FastRate
 		} else if alertType == FAIL {
 			rate = r.SuccessRate
 		}
-		alertString.WriteString("\n     " + strconv.Itoa(i + 1) + ". " + "调用" + strconv.FormatUint(uint64(r.Count), 10) + "次，" + alertTypeString + "为" + strconv.FormatFloat(float64(rate * 100), 'f', 2, 64) + "%")
+		alertString.WriteString("，" + alertTypeString + "为" + strconv.FormatFloat(float64(rate * 100), 'f', 2, 64) + "%")
 	}
-	os.Stderr.WriteString(alertString.String() + "\n")
 }
\ No newline at end of file
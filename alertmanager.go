@@ -0,0 +1,443 @@
+package monitor
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 告警管理器，可以被多个客户端共享，负责将各客户端的告警事件按路由规则分发给命名的接收者
+// 客户端配置了告警管理器之后，未定制AlertCaller等回调时不再默认输出到控制台
+type AlertManager struct {
+	// 路由规则，按顺序匹配，匹配成功且未设置Continue时停止匹配后续的同级路由
+	Routes []Route
+	// 没有任何路由匹配时的接收者
+	DefaultReceiver string
+	// 命名的接收者
+	Receivers map[string]Receiver
+	// 分组依据，可以是clientName、interfaceName、alertType、severity或客户端的标签名，默认按clientName分组
+	GroupBy []string
+	// 同一分组第一条告警事件到达之后等待多久再发出通知，期间到达的事件将合并为一条通知，默认为0即不分组，每条事件单独通知
+	GroupWait time.Duration
+	// 同一分组两次通知之间的最小间隔，期间到达的事件将合并到下一次通知，默认为0即不限制
+	GroupInterval time.Duration
+	// 抑制规则，处于告警中的源告警将抑制目标告警的通知
+	InhibitRules []InhibitRule
+
+	// 分组的状态
+	groups map[string]*alertGroup
+	// 所有共享本管理器的客户端中处于告警中的告警，按告警标识存储最近一次事件
+	active map[string]*AlertEvent
+	// 被抑制的告警，按告警标识存储
+	inhibited map[string]*InhibitedAlert
+	// 状态的锁，告警管理器可能被多个客户端的告警分析模块同时调用
+	lock sync.Mutex
+}
+
+// 抑制规则，例如数据库客户端处于失败告警时，抑制依赖它的http条目的告警
+type InhibitRule struct {
+	// 源告警的选择条件
+	Source AlertSelector
+	// 目标告警的选择条件
+	Target AlertSelector
+	// 源告警与目标告警必须相同的标签，可以是clientName、interfaceName、alertType、severity或客户端的标签名
+	Equal []string
+}
+
+// 告警的选择条件，各条件为空时表示全部匹配，所有条件都满足才算匹配
+type AlertSelector struct {
+	// 匹配的客户端名称，支持*和?通配符
+	ClientName string
+	// 匹配的条目，支持*和?通配符
+	EntryPattern string
+	// 匹配的告警类型
+	AlertTypes []AlertType
+	// 匹配的告警级别
+	Severities []Severity
+}
+
+// 被抑制的告警
+type InhibitedAlert struct {
+	// 被抑制的最近一次告警事件
+	Event *AlertEvent `json:"event"`
+	// 抑制它的源告警事件
+	InhibitedBy *AlertEvent `json:"inhibitedBy"`
+}
+
+// 一个分组等待发出的告警事件
+type alertGroup struct {
+	receiver string
+	labels map[string]string
+	// 等待发出的事件
+	events []*AlertEvent
+	// 等待发出的定时器，为nil表示当前没有等待发出的通知
+	timer *time.Timer
+	// 上一次发出通知的时间
+	lastSent time.Time
+}
+
+// 路由规则，各匹配条件为空时表示全部匹配，所有条件都满足才算匹配
+type Route struct {
+	// 匹配的客户端名称，支持*和?通配符
+	ClientName string
+	// 匹配的条目，支持*和?通配符
+	EntryPattern string
+	// 匹配的告警类型
+	AlertTypes []AlertType
+	// 匹配的告警级别
+	Severities []Severity
+	// 接收者名称，为空时沿用上级路由的接收者
+	Receiver string
+	// 匹配成功之后是否继续匹配后续的同级路由
+	Continue bool
+	// 子路由，匹配成功后继续在子路由中细分，子路由都不匹配时由本路由的接收者接收
+	Routes []Route
+}
+
+// 接收者，接收发往它的通知
+type Receiver func(n *Notification)
+
+// 发往接收者的一条通知，启用分组时可能合并了同一分组的多条告警事件
+type Notification struct {
+	// 接收者名称
+	Receiver string `json:"receiver"`
+	// 分组标识，由接收者和分组标签组成
+	GroupKey string `json:"groupKey"`
+	// 分组标签
+	GroupLabels map[string]string `json:"groupLabels"`
+	// 告警事件
+	Events []*AlertEvent `json:"events"`
+}
+
+// 检查路由引用的接收者是否都已定义
+func (m *AlertManager) validate() {
+	if _, ok := m.Receivers[m.DefaultReceiver]; m.DefaultReceiver != "" && !ok {
+		panic("未定义的默认接收者：" + m.DefaultReceiver)
+	}
+	var check func(routes []Route)
+	check = func(routes []Route) {
+		for _, r := range routes {
+			if _, ok := m.Receivers[r.Receiver]; r.Receiver != "" && !ok {
+				panic("路由引用了未定义的接收者：" + r.Receiver)
+			}
+			check(r.Routes)
+		}
+	}
+	check(m.Routes)
+}
+
+// 分发告警事件，被抑制的告警不发出通知
+func (m *AlertManager) dispatch(e *AlertEvent) {
+	inhibited, released := m.inhibit(e)
+	if !inhibited {
+		m.send(e)
+	}
+	// 源告警恢复之后，不再被抑制且仍在告警中的目标告警需要补发通知
+	for _, r := range released {
+		m.send(r)
+	}
+}
+
+// 更新告警状态并判断告警事件是否被抑制，同时返回因本事件而解除抑制的告警
+func (m *AlertManager) inhibit(e *AlertEvent) (bool, []*AlertEvent) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.active == nil {
+		m.active = map[string]*AlertEvent {}
+		m.inhibited = map[string]*InhibitedAlert {}
+	}
+	key := alertKey(e)
+	if e.EventType == RESOLVED {
+		delete(m.active, key)
+		// 被抑制的告警恢复时，恢复通知同样无需发出
+		if _, ok := m.inhibited[key]; ok {
+			delete(m.inhibited, key)
+			return true, nil
+		}
+		var released []*AlertEvent
+		for targetKey, inhibited := range m.inhibited {
+			if m.inhibitedBy(inhibited.Event) == nil {
+				delete(m.inhibited, targetKey)
+				firing := *inhibited.Event
+				firing.EventType = FIRING
+				released = append(released, &firing)
+			}
+		}
+		return false, released
+	}
+	m.active[key] = e
+	if source := m.inhibitedBy(e); source != nil {
+		m.inhibited[key] = &InhibitedAlert {Event: e, InhibitedBy: source}
+		return true, nil
+	}
+	delete(m.inhibited, key)
+	return false, nil
+}
+
+// 查找抑制该告警事件的源告警，需在持有锁时调用
+func (m *AlertManager) inhibitedBy(e *AlertEvent) *AlertEvent {
+	for _, rule := range m.InhibitRules {
+		if !rule.Target.match(e) {
+			continue
+		}
+		for key, source := range m.active {
+			if key == alertKey(e) || !rule.Source.match(source) {
+				continue
+			}
+			equal := true
+			for _, name := range rule.Equal {
+				if alertLabel(source, name) != alertLabel(e, name) {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				return source
+			}
+		}
+	}
+	return nil
+}
+
+// 处于告警中的告警，包括被抑制的告警
+func (m *AlertManager) ActiveAlerts() []*AlertEvent {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	alerts := make([]*AlertEvent, 0, len(m.active))
+	for _, e := range m.active {
+		alerts = append(alerts, e)
+	}
+	sortAlertEvents(alerts)
+	return alerts
+}
+
+// 被抑制的告警
+func (m *AlertManager) InhibitedAlerts() []InhibitedAlert {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	alerts := make([]InhibitedAlert, 0, len(m.inhibited))
+	events := make([]*AlertEvent, 0, len(m.inhibited))
+	for _, inhibited := range m.inhibited {
+		events = append(events, inhibited.Event)
+	}
+	sortAlertEvents(events)
+	for _, e := range events {
+		alerts = append(alerts, *m.inhibited[alertKey(e)])
+	}
+	return alerts
+}
+
+// 告警的唯一标识
+func alertKey(e *AlertEvent) string {
+	return e.ClientName + "\x00" + e.InterfaceName + "\x00" + strconv.Itoa(int(e.AlertType))
+}
+
+// 按客户端、条目、告警类型排序，便于展示
+func sortAlertEvents(events []*AlertEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		return alertKey(events[i]) < alertKey(events[j])
+	})
+}
+
+// 发出告警事件
+func (m *AlertManager) send(e *AlertEvent) {
+	for _, name := range m.route(e) {
+		if receiver, ok := m.Receivers[name]; ok {
+			labels := m.groupLabels(e)
+			if m.GroupWait <= 0 && m.GroupInterval <= 0 {
+				receiver(&Notification {
+					Receiver: name,
+					GroupKey: groupKey(name, labels),
+					GroupLabels: labels,
+					Events: []*AlertEvent {e},
+				})
+			} else {
+				m.addToGroup(name, labels, e)
+			}
+		}
+	}
+}
+
+// 计算告警事件的分组标签
+func (m *AlertManager) groupLabels(e *AlertEvent) map[string]string {
+	groupBy := m.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = []string {"clientName"}
+	}
+	labels := map[string]string {}
+	for _, name := range groupBy {
+		labels[name] = alertLabel(e, name)
+	}
+	return labels
+}
+
+// 获取告警事件的标签值，可以是clientName、interfaceName、alertType、severity或客户端的标签名
+func alertLabel(e *AlertEvent, name string) string {
+	switch name {
+	case "clientName":
+		return e.ClientName
+	case "interfaceName":
+		return e.InterfaceName
+	case "alertType":
+		return strconv.Itoa(int(e.AlertType))
+	case "severity":
+		return strconv.Itoa(int(alertSeverity(e)))
+	}
+	return e.Labels[name]
+}
+
+// 告警事件所属告警的级别，恢复事件取恢复之前的级别，使恢复通知与告警通知经过相同的路由、分组与抑制规则
+func alertSeverity(e *AlertEvent) Severity {
+	if e.EventType == RESOLVED {
+		return e.PrevSeverity
+	}
+	return e.Severity
+}
+
+// 由接收者和分组标签生成分组标识
+func groupKey(receiver string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	key.WriteString(receiver + ":{")
+	for i, name := range names {
+		if i > 0 {
+			key.WriteString(",")
+		}
+		key.WriteString(name + "=" + strconv.Quote(labels[name]))
+	}
+	key.WriteString("}")
+	return key.String()
+}
+
+// 将告警事件加入分组等待发出，同一条目同一告警类型的同类事件只保留最新的一条
+func (m *AlertManager) addToGroup(receiver string, labels map[string]string, e *AlertEvent) {
+	key := groupKey(receiver, labels)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.groups == nil {
+		m.groups = map[string]*alertGroup {}
+	}
+	group, ok := m.groups[key]
+	if !ok {
+		group = &alertGroup {receiver: receiver, labels: labels}
+		m.groups[key] = group
+	}
+	replaced := false
+	for i, pending := range group.events {
+		if pending.ClientName == e.ClientName && pending.InterfaceName == e.InterfaceName && pending.AlertType == e.AlertType && pending.EventType == e.EventType {
+			group.events[i] = e
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		group.events = append(group.events, e)
+	}
+	if group.timer != nil {
+		return
+	}
+	// 首次通知等待GroupWait，之后还需满足与上一次通知的最小间隔
+	wait := m.GroupWait
+	if !group.lastSent.IsZero() {
+		if next := time.Until(group.lastSent.Add(m.GroupInterval)); next > wait {
+			wait = next
+		}
+	}
+	group.timer = time.AfterFunc(wait, func() {
+		m.flush(key)
+	})
+}
+
+// 发出分组中等待的告警事件
+func (m *AlertManager) flush(key string) {
+	m.lock.Lock()
+	group := m.groups[key]
+	events := group.events
+	group.events = nil
+	group.timer = nil
+	group.lastSent = time.Now()
+	receiver := m.Receivers[group.receiver]
+	m.lock.Unlock()
+	if len(events) > 0 && receiver != nil {
+		receiver(&Notification {
+			Receiver: group.receiver,
+			GroupKey: key,
+			GroupLabels: group.labels,
+			Events: events,
+		})
+	}
+}
+
+// 计算告警事件应当发往的接收者，同一个接收者只发一次
+func (m *AlertManager) route(e *AlertEvent) []string {
+	root := Route {Receiver: m.DefaultReceiver, Routes: m.Routes}
+	receivers := root.route(e, "")
+	names := make([]string, 0, len(receivers))
+	seen := map[string]bool {}
+	for _, name := range receivers {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 在已经匹配的路由下继续匹配子路由，返回接收者
+func (r *Route) route(e *AlertEvent, parentReceiver string) []string {
+	receiver := r.Receiver
+	if receiver == "" {
+		receiver = parentReceiver
+	}
+	var receivers []string
+	for i := range r.Routes {
+		child := &r.Routes[i]
+		if !child.match(e) {
+			continue
+		}
+		receivers = append(receivers, child.route(e, receiver)...)
+		if !child.Continue {
+			break
+		}
+	}
+	if len(receivers) == 0 {
+		receivers = []string {receiver}
+	}
+	return receivers
+}
+
+// 路由规则是否匹配告警事件
+func (r *Route) match(e *AlertEvent) bool {
+	selector := AlertSelector {
+		ClientName: r.ClientName,
+		EntryPattern: r.EntryPattern,
+		AlertTypes: r.AlertTypes,
+		Severities: r.Severities,
+	}
+	return selector.match(e)
+}
+
+// 选择条件是否匹配告警事件
+func (s *AlertSelector) match(e *AlertEvent) bool {
+	if s.ClientName != "" && !matchPattern(s.ClientName, e.ClientName) {
+		return false
+	}
+	if !matchAlert(s.EntryPattern, s.AlertTypes, e.InterfaceName, e.AlertType) {
+		return false
+	}
+	if len(s.Severities) == 0 {
+		return true
+	}
+	for _, severity := range s.Severities {
+		if severity == alertSeverity(e) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package monitor
 
 import (
 	"time"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -36,6 +38,24 @@ type OutPutData struct {
 	FailDistribution map[string]uint32 `json:"failDistribution"`
 	// 时延分布情况
 	TimeConsumingDistribution map[string]uint32 `json:"timeConsumingDistribution"`
+	// 调用次数未达到最少调用次数，本周期不参与成功率与时延达标率告警
+	InsufficientData bool `json:"insufficientData,omitempty"`
+	// 调用量基线，即最近若干个正常周期的平均调用次数，仅在启用调用量下降告警且基线建立之后输出
+	TrafficBaseline float64 `json:"trafficBaseline,omitempty"`
+	// 错误预算情况，仅在条目定义了SLO时输出
+	ErrorBudget *ErrorBudget `json:"errorBudget,omitempty"`
+	// 异常检测结果，仅在条目启用异常检测且数据充足时输出
+	Anomaly *AnomalyData `json:"anomaly,omitempty"`
+	// 数据的粒度，例如"5m"、"1h"，仅在降采样输出的数据中有值，此时Timestamp为窗口的开始时间
+	Resolution string `json:"resolution,omitempty"`
+	// 成功总耗时，用于合并降采样窗口
+	successMsCount uint64
+	// 条目的配置，随数据流入告警分析
+	config *EntryConfig
+	// 本周期生效的告警阈值，随数据流入告警分析
+	thresholds *Thresholds
+	// 条目已被移除，告警分析模块据此结束条目的告警
+	evicted bool
 }
 
 // 存储一些最近状态，以用于实现告警、恢复等机制
@@ -43,27 +63,49 @@ type alertStatus struct {
 	recentAlertOutput   []OutPutData // 最近连续几次失败的数据
 	recentRecoverOutput []OutPutData // 自最近一次告警之后，连续成功的几次数据
 	curState            AlertType    // 当前是否处于告警之后检测恢复的状态
+	firingOutput        []OutPutData // 触发告警时连续不达标的数据，告警因静默未能发出时留待静默结束后补发
+	notified            bool         // 当前告警是否已经发出通知，只有发出过告警的才会发出恢复通知
+	startsAt            time.Time    // 当前告警的开始时间，即第一个不达标周期的时间
+	notifiedAt          time.Time    // 最近一次发出告警通知的时间，用于计算重复通知的间隔
+	escalated           bool         // 当前告警是否已经升级通知
+	severity            Severity     // 当前告警的级别
+	prevSeverity        Severity     // 最近一次级别变化之前的级别
+	recentHits          []bool       // 滑动窗口模式下，最近各周期是否满足告警（告警之前）或恢复（告警之后）的条件
+	transitions         []time.Time  // FlapWindow内告警与恢复的状态变化时间，用于抖动检测
+	flapping            bool         // 是否处于抖动中
+	pendingResolved     *AlertEvent  // 静默期间恢复的告警，恢复通知留待静默结束之后补发
 }
 
 // 周期性启动分析任务
 func (c *ReportClientConfig) scheduleTask() {
 	// 定时统计
-	t := time.NewTicker(time.Duration(c.StatisticalCycle) * time.Millisecond)
-	for curTime := range t.C {
-		for _, collectData := range c.collectDataMap  {
-			c.taskChannel <- &taskQueue {
-				taskType: CLEAR,
-				data: clearData {
-					Name: collectData.Name,
-					Time: curTime,
-				},
-			}
+	tick, stop := c.Clock.NewTicker(time.Duration(c.StatisticalCycle) * time.Millisecond)
+	defer stop()
+	for {
+		var curTime time.Time
+		select {
+		case curTime = <-tick:
+		case <-c.done:
+			return
+		}
+		// 条目的扫描交由收集模块完成，避免与收集模块并发读写collectDataMap
+		select {
+		case c.taskChannel <- &taskQueue {
+			taskType: CLEAR,
+			data: clearData {
+				Time: curTime,
+			},
+		}:
+		case <-c.done:
+			return
 		}
 	}
 }
 
 // 分析统计
 func (c *ReportClientConfig) statistics() {
+	// 统计分析通道关闭后通知告警分析模块退出
+	defer close(c.alertChannel)
 	// 以具体条目为单位进行统计分析
 	for collectedData := range c.statisticsChannel {
 		// 常规指标统计
@@ -71,55 +113,57 @@ func (c *ReportClientConfig) statistics() {
 		outputData.ClientName = c.Name
 		outputData.InterfaceName = collectedData.Name
 		outputData.Count = collectedData.FailCount + collectedData.SuccessCount
-		outputData.SuccessRate = float64(collectedData.SuccessCount) / float64(outputData.Count)
-		outputData.FastRate = float64(collectedData.FastCount) / float64(outputData.Count)
-		outputData.FastCount = collectedData.FastCount
-		outputData.SuccessMsAver = uint32(float64(collectedData.SuccessMsCount) / float64(outputData.Count))
-		outputData.SuccessCount = collectedData.SuccessCount
-		outputData.FailCount = collectedData.FailCount
-		outputData.MaxMs = collectedData.MaxMs
-		outputData.MinMs = collectedData.MinMs
 		outputData.Timestamp = collectedData.Time.UTC()
-		outputData.TimeConsumingDistribution = map[string]uint32 {}
-		outputData.FailDistribution = map[string]uint32 {}
-
-
-		// 时延分布统计
-		scope := (collectedData.Config.TimeConsumingDistributionMax - collectedData.Config.TimeConsumingDistributionMin) / uint32(collectedData.Config.TimeConsumingDistributionSplit - 2)
-		// 计算第一个区间
-		outputData.TimeConsumingDistribution["<" + strconv.FormatUint(uint64(collectedData.Config.TimeConsumingDistributionMin), 10)] = collectedData.TimeConsumingDistribution[0]
-		// 计算最后一个区间
-		outputData.TimeConsumingDistribution[">" + strconv.FormatUint(uint64(collectedData.Config.TimeConsumingDistributionMax), 10)] = collectedData.TimeConsumingDistribution[collectedData.Config.TimeConsumingDistributionSplit - 1]
-		// 计算剩余区间
-		for i := 1; i < collectedData.Config.TimeConsumingDistributionSplit - 1; i++ {
-			start := int(collectedData.Config.TimeConsumingDistributionMin + uint32(i - 1) * scope)
-			var end int
-			if i == collectedData.Config.TimeConsumingDistributionSplit - 1 {
-				end = int(collectedData.Config.TimeConsumingDistributionMax)
-			} else {
-				end = int(collectedData.Config.TimeConsumingDistributionMin + uint32(i) * scope)
+		outputData.config = collectedData.Config
+		outputData.thresholds = collectedData.thresholds
+		// 条目已被移除，清理统计分析中的状态之后交由告警分析模块结束其告警
+		if collectedData.evicted {
+			c.evictEntry(collectedData.Name)
+			outputData.evicted = true
+			c.alertChannel <- outputData
+			continue
+		}
+		// 调用量基线统计
+		if c.TrafficDropRate > 0 {
+			outputData.TrafficBaseline = c.trafficAnalyze(&collectedData)
+		}
+		// 没有上报数据的周期不输出统计数据，只参与无数据与调用量下降的告警分析
+		if outputData.Count == 0 {
+			if len(c.Resolutions) > 0 {
+				c.downsample(&outputData)
 			}
-			outputData.TimeConsumingDistribution[strconv.Itoa(start) + "~" + strconv.Itoa(end)] = collectedData.TimeConsumingDistribution[i]
+			c.alertChannel <- outputData
+			continue
 		}
+		c.fillOutputData(&outputData, &collectedData)
 
+		// 错误预算统计
+		if collectedData.Config.SLO != nil {
+			outputData.ErrorBudget = c.sloAnalyze(&collectedData, outputData.Timestamp)
+		}
 
-		// 失败分布统计
-		for status, count := range collectedData.FailDistribution {
-			var name string
-			if c.GetCodeFeature != nil {
-				_, name = c.GetCodeFeature(status)
-			} else if s, ok := c.CodeFeatureMap[status]; ok && s.Name != "" {
-				name = s.Name
-			}
-			if name != "" {
-				outputData.FailDistribution[name] = count
-			} else {
-				outputData.FailDistribution[strings.Replace(c.DefaultFailDistributionFormat, "%code", strconv.Itoa(status), 1)] = count
+		// 异常检测，数据不足的周期既不检测也不计入基线
+		if collectedData.Config.Anomaly != nil && !outputData.InsufficientData {
+			detector, ok := c.anomalyDetectorMap[collectedData.Name]
+			if !ok {
+				detector = &anomalyDetector {}
+				c.anomalyDetectorMap[collectedData.Name] = detector
 			}
+			outputData.Anomaly = detector.detect(&outputData, collectedData.Config.Anomaly)
+		}
+
+		// 降采样到各个粗粒度窗口
+		if len(c.Resolutions) > 0 {
+			c.downsample(&outputData)
 		}
 
-		// 告警分析：由于告警分析存在对定制化告警函数的调用可能性，无法预估性能，所以启用新的gorouting去执行避免不可预测的风险
-		go c.alertAnalyze(collectedData.Name, outputData)
+		// 保留最近的统计数据以供查询
+		if c.HistorySize > 0 {
+			c.outputHistory.add(outputData)
+		}
+
+		// 告警分析：由于告警分析存在对定制化告警函数的调用可能性，无法预估性能，所以交由告警分析模块执行避免阻塞统计
+		c.alertChannel <- outputData
 
 		// 输出最终统计数据
 		if c.OutputCaller != nil {
@@ -130,94 +174,488 @@ func (c *ReportClientConfig) statistics() {
 	}
 }
 
+// 根据收集的数据计算比例、耗时与分布等常规指标，outputData中需已设置调用总次数
+func (c *ReportClientConfig) fillOutputData(outputData *OutPutData, collectedData *reportData) {
+	outputData.SuccessRate = float64(collectedData.SuccessCount) / float64(outputData.Count)
+	outputData.FastRate = float64(collectedData.FastCount) / float64(outputData.Count)
+	outputData.FastCount = collectedData.FastCount
+	outputData.SuccessMsAver = uint32(float64(collectedData.SuccessMsCount) / float64(outputData.Count))
+	outputData.successMsCount = collectedData.SuccessMsCount
+	outputData.SuccessCount = collectedData.SuccessCount
+	outputData.FailCount = collectedData.FailCount
+	outputData.MaxMs = collectedData.MaxMs
+	outputData.MinMs = collectedData.MinMs
+	outputData.TimeConsumingDistribution = map[string]uint32 {}
+	outputData.FailDistribution = map[string]uint32 {}
+	thresholds := collectedData.thresholds
+	if thresholds == nil {
+		thresholds = c.currentThresholds()
+	}
+	minRequestCount := thresholds.MinRequestCount
+	if collectedData.Config.MinRequestCount > 0 {
+		minRequestCount = collectedData.Config.MinRequestCount
+	}
+	outputData.InsufficientData = int(outputData.Count) < minRequestCount
+
+
+	// 时延分布统计
+	scope := (collectedData.Config.TimeConsumingDistributionMax - collectedData.Config.TimeConsumingDistributionMin) / uint32(collectedData.Config.TimeConsumingDistributionSplit - 2)
+	// 计算第一个区间
+	outputData.TimeConsumingDistribution["<" + strconv.FormatUint(uint64(collectedData.Config.TimeConsumingDistributionMin), 10)] = collectedData.TimeConsumingDistribution[0]
+	// 计算最后一个区间
+	outputData.TimeConsumingDistribution[">" + strconv.FormatUint(uint64(collectedData.Config.TimeConsumingDistributionMax), 10)] = collectedData.TimeConsumingDistribution[collectedData.Config.TimeConsumingDistributionSplit - 1]
+	// 计算剩余区间
+	for i := 1; i < collectedData.Config.TimeConsumingDistributionSplit - 1; i++ {
+		start := int(collectedData.Config.TimeConsumingDistributionMin + uint32(i - 1) * scope)
+		var end int
+		if i == collectedData.Config.TimeConsumingDistributionSplit - 1 {
+			end = int(collectedData.Config.TimeConsumingDistributionMax)
+		} else {
+			end = int(collectedData.Config.TimeConsumingDistributionMin + uint32(i) * scope)
+		}
+		outputData.TimeConsumingDistribution[strconv.Itoa(start) + "~" + strconv.Itoa(end)] = collectedData.TimeConsumingDistribution[i]
+	}
+
+
+	// 失败分布统计
+	for status, count := range collectedData.FailDistribution {
+		var name string
+		if c.GetCodeFeature != nil {
+			_, name = c.GetCodeFeature(status)
+		} else if s, ok := c.CodeFeatureMap[status]; ok && s.Name != "" {
+			name = s.Name
+		}
+		if name != "" {
+			outputData.FailDistribution[name] = count
+		} else {
+			outputData.FailDistribution[strings.Replace(c.DefaultFailDistributionFormat, "%code", strconv.Itoa(status), 1)] = count
+		}
+	}
+}
+
+// 记录本周期的数据并计算错误预算
+func (c *ReportClientConfig) sloAnalyze(collectedData *reportData, now time.Time) *ErrorBudget {
+	tracker, ok := c.sloTrackerMap[collectedData.Name]
+	if !ok {
+		tracker = &sloTracker {}
+		c.sloTrackerMap[collectedData.Name] = tracker
+	}
+	tracker.add(sloSample {
+		Time: now,
+		Count: uint64(collectedData.SuccessCount) + uint64(collectedData.FailCount),
+		FailCount: uint64(collectedData.FailCount),
+		SuccessCount: uint64(collectedData.SuccessCount),
+		SlowCount: uint64(collectedData.SuccessCount - collectedData.FastCount),
+	}, collectedData.Config.SLO)
+	return tracker.budget(now, collectedData.Config.SLO)
+}
+
+// 计算本周期之前的调用量基线，并将本周期计入基线
+// 调用量下降的周期不计入基线，以免持续的下降拉低基线而被误判为恢复
+func (c *ReportClientConfig) trafficAnalyze(collectedData *reportData) float64 {
+	counts := c.trafficBaselineMap[collectedData.Name]
+	count := collectedData.SuccessCount + collectedData.FailCount
+	var baseline float64
+	if len(counts) >= c.TrafficBaselineCycles {
+		var sum uint64
+		for _, n := range counts {
+			sum += uint64(n)
+		}
+		baseline = float64(sum) / float64(len(counts))
+	}
+	if baseline == 0 || !trafficDropped(count, baseline, c.TrafficDropRate) {
+		counts = append(counts, count)
+		if len(counts) > c.TrafficBaselineCycles {
+			counts = counts[1:]
+		}
+		c.trafficBaselineMap[collectedData.Name] = counts
+	}
+	return baseline
+}
+
+// 调用量相对基线的下降比例是否达到阈值
+func trafficDropped(count uint32, baseline float64, dropRate float64) bool {
+	return float64(count) <= baseline * (1 - dropRate)
+}
+
+// 告警分析
+func (c *ReportClientConfig) alert() {
+	// 告警分析模块退出后关闭通知队列，通知模块送达剩余的事件后退出
+	defer c.eventQueue.close()
+	var cycleTime time.Time
+	for outputData := range c.alertChannel {
+		// 同一周期的数据带有相同的时间，新周期的数据到达意味着上一个周期的分析已经完成，此时保存告警状态
+		if c.StateStore != nil && !outputData.Timestamp.Equal(cycleTime) {
+			if !cycleTime.IsZero() {
+				c.saveState(cycleTime)
+			}
+			cycleTime = outputData.Timestamp
+		}
+		if outputData.evicted {
+			c.evictAlerts(outputData.InterfaceName, outputData)
+			continue
+		}
+		c.alertAnalyze(outputData.InterfaceName, outputData)
+	}
+	// 客户端已关闭，保存最后一个周期的告警状态
+	if c.StateStore != nil && !cycleTime.IsZero() {
+		c.saveState(cycleTime)
+	}
+}
+
+// 清理被移除的条目在统计分析中的状态，只在统计分析模块中调用，降采样窗口中尚未输出的数据随之丢弃
+func (c *ReportClientConfig) evictEntry(entryName string) {
+	delete(c.sloTrackerMap, entryName)
+	delete(c.trafficBaselineMap, entryName)
+	delete(c.anomalyDetectorMap, entryName)
+	delete(c.resolutionMap, entryName)
+	c.outputHistory.remove(entryName)
+}
+
+// 结束被移除的条目的全部告警，只在告警分析模块中调用
+// 如同条目消失时告警随之结束，已经发出的告警发出恢复通知，之后重新上报时从头开始分析
+func (c *ReportClientConfig) evictAlerts(entryName string, outputData OutPutData) {
+	alertTypes := []AlertType {}
+	for alertType, statusMap := range c.alertStatusMap {
+		if _, ok := statusMap[entryName]; ok {
+			alertTypes = append(alertTypes, alertType)
+		}
+	}
+	sort.Slice(alertTypes, func(i, j int) bool {
+		return alertTypes[i] < alertTypes[j]
+	})
+	for _, alertType := range alertTypes {
+		status := c.alertStatusMap[alertType][entryName]
+		if status.curState != NONE {
+			c.recordAlert(status, alertType, entryName, RESOLVED, outputData)
+			// 处于静默中时恢复通知无从补发，随条目一并丢弃
+			c.notifyResolved(status, alertType, entryName, []OutPutData {outputData}, outputData.Timestamp)
+		}
+		delete(c.alertStatusMap[alertType], entryName)
+	}
+	c.publishAlerts(entryName)
+}
+
+// 获取条目某种告警类型的状态，不存在则初始化
+func (c *ReportClientConfig) getAlertStatus(alertType AlertType, entryName string) *alertStatus {
+	statusMap, ok := c.alertStatusMap[alertType]
+	if !ok {
+		statusMap = map[string]*alertStatus {}
+		c.alertStatusMap[alertType] = statusMap
+	}
+	if _, ok := statusMap[entryName]; !ok {
+		statusMap[entryName] = &alertStatus {
+			recentAlertOutput: make([]OutPutData, 0),
+		}
+	}
+	return statusMap[entryName]
+}
+
 // 告警相关的分析
 func (c *ReportClientConfig) alertAnalyze(entryName string, outputData OutPutData) {
-	// 时延达标率告警和恢复分析
-	if _, ok := c.recentFastRateStatus[entryName]; !ok {
-		c.recentFastRateStatus[entryName] = &alertStatus {
-			recentAlertOutput: make([]OutPutData, 0),
+	// 分析完成之后更新可供查询的告警状态
+	defer c.publishAlerts(entryName)
+	// 条目的告警阈值与周期数，条目未设置的沿用客户端的配置
+	rule := c.alertRule(outputData.config, outputData.thresholds)
+	window := rule.AlertWindow
+	// 无数据告警与恢复分析
+	if c.AlertForNoDataReachedTimes > 0 {
+		c.checkAlertStatus(c.getAlertStatus(NO_DATA, entryName), NO_DATA, entryName,
+			criticalIf(outputData.Count == 0), outputData, c.AlertForNoDataReachedTimes, 1, window)
+	}
+
+	// 调用量下降告警与恢复分析，启用无数据告警时，完全没有调用的周期交由无数据告警处理
+	if c.TrafficDropRate > 0 && outputData.TrafficBaseline > 0 && (outputData.Count > 0 || c.AlertForNoDataReachedTimes == 0) {
+		c.checkAlertStatus(c.getAlertStatus(TRAFFIC_DROP, entryName), TRAFFIC_DROP, entryName,
+			criticalIf(trafficDropped(outputData.Count, outputData.TrafficBaseline, c.TrafficDropRate)), outputData,
+			c.AlertForTrafficDropReachedTimes, c.AlertForTrafficDropReachedTimes, window)
+	}
+
+	// 没有调用的周期无从判断成功率与时延
+	if outputData.Count == 0 {
+		return
+	}
+
+	// 数据不足的周期不具备统计意义，既不推进告警也不打断恢复
+	if !outputData.InsufficientData {
+		// 时延达标率告警和恢复分析，时延不达标告警只在有成功请求时才触发统计
+		fastLevel := NORMAL
+		if outputData.SuccessCount > 0 {
+			fastLevel = c.rateLevel(outputData.FastCount, outputData.Count, rule.FastRate, rule.WarningFastRate)
 		}
+		c.checkAlertStatus(c.getAlertStatus(SLOW, entryName), SLOW, entryName, fastLevel, outputData,
+			rule.AlertForBadFastRateReachedTimes, rule.AlertForGreatFastRateReachedTimes, window)
+
+		// 访问成功率告警与恢复分析
+		c.checkAlertStatus(c.getAlertStatus(FAIL, entryName), FAIL, entryName,
+			c.rateLevel(outputData.SuccessCount, outputData.Count, rule.SuccessRate, rule.WarningSuccessRate), outputData,
+			rule.AlertForBadSuccessRateReachedTimes, rule.AlertForGreatSuccessRateReachedTimes, window)
 	}
-	curFastRateStatus := c.recentFastRateStatus[entryName]
-	if _, ok := c.recentSuccessRateStatus[entryName]; !ok {
-		c.recentSuccessRateStatus[entryName] = &alertStatus {
-			recentAlertOutput: make([]OutPutData, 0),
+
+	// 错误预算燃烧率告警与恢复分析，多窗口规则本身已经兼顾了显著性和恢复速度，所以一次达到条件即告警，一次不满足即恢复，也不采用滑动窗口
+	if outputData.ErrorBudget != nil {
+		if outputData.ErrorBudget.Availability != nil {
+			c.checkAlertStatus(c.getAlertStatus(FAIL_BUDGET, entryName), FAIL_BUDGET, entryName,
+				outputData.ErrorBudget.Availability.burningLevel(), outputData, 1, 1, 0)
+		}
+		if outputData.ErrorBudget.Latency != nil {
+			c.checkAlertStatus(c.getAlertStatus(SLOW_BUDGET, entryName), SLOW_BUDGET, entryName,
+				outputData.ErrorBudget.Latency.burningLevel(), outputData, 1, 1, 0)
+		}
+	}
+
+	// 异常告警与恢复分析
+	if outputData.Anomaly != nil {
+		anomalyConfig := outputData.config.Anomaly
+		c.checkAlertStatus(c.getAlertStatus(ANOMALY, entryName), ANOMALY, entryName,
+			criticalIf(outputData.Anomaly.Anomalous), outputData, anomalyConfig.AlertTimes, anomalyConfig.RecoverTimes, window)
+	}
+}
+
+// 条目生效的告警阈值、告警与恢复周期数以及滑动窗口，条目未设置的沿用客户端的告警阈值，thresholds为nil时取最新的告警阈值
+func (c *ReportClientConfig) alertRule(config *EntryConfig, thresholds *Thresholds) EntryConfig {
+	if thresholds == nil {
+		thresholds = c.currentThresholds()
+	}
+	rule := EntryConfig {
+		SuccessRate: thresholds.SuccessRate,
+		FastRate: thresholds.FastRate,
+		WarningSuccessRate: thresholds.WarningSuccessRate,
+		WarningFastRate: thresholds.WarningFastRate,
+		AlertForBadSuccessRateReachedTimes: thresholds.AlertForBadSuccessRateReachedTimes,
+		AlertForBadFastRateReachedTimes: thresholds.AlertForBadFastRateReachedTimes,
+		AlertForGreatSuccessRateReachedTimes: thresholds.AlertForGreatSuccessRateReachedTimes,
+		AlertForGreatFastRateReachedTimes: thresholds.AlertForGreatFastRateReachedTimes,
+		AlertWindow: thresholds.AlertWindow,
+	}
+	if config == nil {
+		return rule
+	}
+	if config.SuccessRate > 0 {
+		rule.SuccessRate = config.SuccessRate
+	}
+	if config.FastRate > 0 {
+		rule.FastRate = config.FastRate
+	}
+	if config.WarningSuccessRate > 0 {
+		rule.WarningSuccessRate = config.WarningSuccessRate
+	}
+	if config.WarningFastRate > 0 {
+		rule.WarningFastRate = config.WarningFastRate
+	}
+	if config.AlertForBadSuccessRateReachedTimes > 0 {
+		rule.AlertForBadSuccessRateReachedTimes = config.AlertForBadSuccessRateReachedTimes
+	}
+	if config.AlertForBadFastRateReachedTimes > 0 {
+		rule.AlertForBadFastRateReachedTimes = config.AlertForBadFastRateReachedTimes
+	}
+	if config.AlertForGreatSuccessRateReachedTimes > 0 {
+		rule.AlertForGreatSuccessRateReachedTimes = config.AlertForGreatSuccessRateReachedTimes
+	}
+	if config.AlertForGreatFastRateReachedTimes > 0 {
+		rule.AlertForGreatFastRateReachedTimes = config.AlertForGreatFastRateReachedTimes
+	}
+	if config.AlertWindow > 0 {
+		rule.AlertWindow = config.AlertWindow
+	}
+	return rule
+}
+
+// 不区分告警级别的规则，不达标即为严重级别
+func criticalIf(bad bool) Severity {
+	if bad {
+		return CRITICAL
+	}
+	return NORMAL
+}
+
+// 根据严重与警告两级阈值判断比例所处的告警级别，警告阈值不高于严重阈值时视为未设置
+func (c *ReportClientConfig) rateLevel(hit uint32, count uint32, critical float64, warning float64) Severity {
+	if c.belowRate(hit, count, critical) {
+		return CRITICAL
+	}
+	if warning > critical && c.belowRate(hit, count, warning) {
+		return WARNING
+	}
+	return NORMAL
+}
+
+// 判断比例是否低于阈值，启用Wilson置信区间时以区间上界作比较，避免小样本下的偶然波动造成误告警
+func (c *ReportClientConfig) belowRate(hit uint32, count uint32, threshold float64) bool {
+	if count == 0 {
+		return false
+	}
+	n := float64(count)
+	p := float64(hit) / n
+	if c.WilsonConfidence > 0 {
+		z := math.Sqrt2 * math.Erfinv(c.WilsonConfidence)
+		p = (p + z * z / (2 * n) + z * math.Sqrt(p * (1 - p) / n + z * z / (4 * n * n))) / (1 + z * z / n)
+	}
+	return p < threshold
+}
+
+// 根据本周期的告警级别推进告警状态：连续alertTimes个周期不达标触发告警，告警之后连续recoverTimes个周期达标触发恢复通知
+// window大于0时改为滑动窗口模式：最近window个周期中有alertTimes个不达标即告警，告警之后最近window个周期中有recoverTimes个达标即恢复
+// 静默期间告警状态照常推进，只是不发出告警与恢复通知，若静默结束时仍处于告警状态则补发告警，已经恢复的则补发恢复通知
+// 告警持续期间，不达标的周期还将按需发出级别变化、重复和升级通知
+func (c *ReportClientConfig) checkAlertStatus(status *alertStatus, alertType AlertType, entryName string, level Severity, outputData OutPutData, alertTimes int, recoverTimes int, window int) {
+	now := outputData.Timestamp
+	if status.flapping {
+		c.checkFlapStable(status, alertType, entryName, outputData)
+	}
+	if status.pendingResolved != nil && !c.silenced(entryName, alertType, now) {
+		c.dispatch(status.pendingResolved)
+		status.pendingResolved = nil
+	}
+	if status.curState == alertType && !status.notified {
+		c.notifyAlert(status, alertType, entryName, FIRING, status.firingOutput, now)
+	}
+	if status.curState == NONE {
+		if status.countCycle(&status.recentAlertOutput, level != NORMAL, outputData, alertTimes, window) < alertTimes {
+			return
 		}
+		// 标记出当前告警的状态
+		status.curState = alertType
+		status.severity = level
+		status.startsAt = status.firstHit().Timestamp
+		status.firingOutput = append([]OutPutData {}, status.recentAlertOutput...)
+		// 静默期间恢复之后再次告警，此前的恢复通知已无必要
+		status.pendingResolved = nil
+		c.trackFlapping(status, alertType, entryName, outputData)
+		// 触发告警，抖动期间不发出
+		c.notifyAlert(status, alertType, entryName, FIRING, status.firingOutput, now)
+		c.recordAlert(status, alertType, entryName, FIRING, outputData)
+		status.recentAlertOutput = status.recentAlertOutput[:0]
+		status.recentHits = status.recentHits[:0]
+		return
 	}
-	curSuccessRateStatus := c.recentSuccessRateStatus[entryName]
-	// 时延不达标告警只在有成功请求时才触发统计
-	if outputData.SuccessCount > 0 && outputData.FastRate < c.FastRate {
-		// 每次失败都将重置恢复计数
-		if len(curFastRateStatus.recentRecoverOutput) > 0 {
-			curFastRateStatus.recentRecoverOutput = curFastRateStatus.recentRecoverOutput[:0]
-		}
-		curFastRateStatus.recentAlertOutput = append(curFastRateStatus.recentAlertOutput, outputData)
-		if curFastRateStatus.curState == NONE && len(curFastRateStatus.recentAlertOutput) >= c.AlertForBadFastRateReachedTimes {
-			// 标记出当前告警的状态
-			curFastRateStatus.curState = SLOW
-			// 触发连续耗时不达标告警
-			if c.AlertCaller != nil {
-				c.AlertCaller(c.Name, entryName, SLOW, curFastRateStatus.recentAlertOutput)
-			} else {
-				defaultAlert(c.Name, entryName, SLOW, curFastRateStatus.recentAlertOutput)
+	if level != NORMAL {
+		if level != status.severity {
+			// 告警级别发生变化，尚未发出告警时只需更新级别，待补发告警时一并体现
+			status.prevSeverity = status.severity
+			status.severity = level
+			c.recordAlert(status, alertType, entryName, SEVERITY_CHANGED, outputData)
+			if status.notified {
+				c.notifyAlert(status, alertType, entryName, SEVERITY_CHANGED, []OutPutData {outputData}, now)
 			}
-			curFastRateStatus.recentAlertOutput = curFastRateStatus.recentAlertOutput[:0]
-		}
-	} else {
-		// 只要一次成功就清空原有不健康记录，实测比判断长度是否大于0再去清空性能要略优
-		curFastRateStatus.recentAlertOutput = curFastRateStatus.recentAlertOutput[:0]
-		// 处于告警状态时 每次成功都累计恢复次数
-		if curFastRateStatus.curState == SLOW {
-			curFastRateStatus.recentRecoverOutput = append(curFastRateStatus.recentRecoverOutput, outputData)
-			if len(curFastRateStatus.recentRecoverOutput) >= c.AlertForGreatFastRateReachedTimes {
-				// 触发恢复通知
-				if c.RecoverCaller != nil {
-					c.RecoverCaller(c.Name, entryName, SLOW, curFastRateStatus.recentRecoverOutput)
-				} else {
-					defaultRecover(c.Name, entryName, SLOW, curFastRateStatus.recentRecoverOutput)
-				}
-				// 重置标志
-				curFastRateStatus.curState = NONE
-				curFastRateStatus.recentAlertOutput = curFastRateStatus.recentAlertOutput[:0]
+		} else if status.notified {
+			// 告警持续超过一定时间后升级通知
+			if c.EscalateAfter > 0 && !status.escalated && now.Sub(status.startsAt) >= c.EscalateAfter {
+				c.notifyAlert(status, alertType, entryName, ESCALATE, []OutPutData {outputData}, now)
+			} else if c.RepeatInterval > 0 && now.Sub(status.notifiedAt) >= c.RepeatInterval {
+				// 告警持续期间重复通知
+				c.notifyAlert(status, alertType, entryName, REPEAT, []OutPutData {outputData}, now)
 			}
 		}
 	}
+	// 处于告警状态时累计恢复次数
+	if status.countCycle(&status.recentRecoverOutput, level == NORMAL, outputData, recoverTimes, window) < recoverTimes {
+		return
+	}
+	c.trackFlapping(status, alertType, entryName, outputData)
+	c.recordAlert(status, alertType, entryName, RESOLVED, outputData)
+	// 触发恢复通知，抖动期间留待稳定之后再补发
+	if !status.flapping {
+		c.notifyResolved(status, alertType, entryName, status.recentRecoverOutput, now)
+	}
+	// 重置标志，恢复数据已随恢复事件发出，不再复用其底层数组
+	status.curState = NONE
+	status.severity = NORMAL
+	status.prevSeverity = NORMAL
+	status.escalated = false
+	status.firingOutput = nil
+	status.recentRecoverOutput = nil
+	status.recentHits = status.recentHits[:0]
+}
 
-	// 访问成功率告警与恢复分析
-	if outputData.SuccessRate < c.SuccessRate {
-		// 每次失败都将重置恢复计数
-		if len(curSuccessRateStatus.recentRecoverOutput) > 0 {
-			curSuccessRateStatus.recentRecoverOutput = curSuccessRateStatus.recentRecoverOutput[:0]
+// 累计一个周期的数据，返回满足条件（hit）的周期数
+// 连续模式下只保留连续满足条件的周期，一次不满足即清空；滑动窗口模式下保留最近window个周期，窗口小于need时按need计算
+func (s *alertStatus) countCycle(recentOutput *[]OutPutData, hit bool, outputData OutPutData, need int, window int) int {
+	if window <= 0 {
+		if !hit {
+			// 只要一次不满足就清空原有记录，实测比判断长度是否大于0再去清空性能要略优
+			*recentOutput = (*recentOutput)[:0]
+			return 0
 		}
-		curSuccessRateStatus.recentAlertOutput = append(curSuccessRateStatus.recentAlertOutput, outputData)
-		if curSuccessRateStatus.curState == NONE && len(curSuccessRateStatus.recentAlertOutput) >= c.AlertForBadSuccessRateReachedTimes {
-			// 标记出当前告警的状态
-			curSuccessRateStatus.curState = FAIL
-			// 触发连续耗时不达标告警
-			if c.AlertCaller != nil {
-				c.AlertCaller(c.Name, entryName, FAIL, curSuccessRateStatus.recentAlertOutput)
-			} else {
-				defaultAlert(c.Name, entryName, FAIL, curSuccessRateStatus.recentAlertOutput)
-			}
-			curSuccessRateStatus.recentAlertOutput = curSuccessRateStatus.recentAlertOutput[:0]
-		}
-	} else {
-		// 只要一次成功就清空原有不健康记录
-		curSuccessRateStatus.recentAlertOutput = curSuccessRateStatus.recentAlertOutput[:0]
-		// 处于告警状态时 每次成功都累计恢复次数
-		if curSuccessRateStatus.curState == FAIL {
-			curSuccessRateStatus.recentRecoverOutput = append(curSuccessRateStatus.recentRecoverOutput, outputData)
-			if len(curSuccessRateStatus.recentRecoverOutput) >= c.AlertForGreatSuccessRateReachedTimes {
-				// 触发恢复通知
-				if c.RecoverCaller != nil {
-					c.RecoverCaller(c.Name, entryName, FAIL, curSuccessRateStatus.recentRecoverOutput)
-				} else {
-					defaultRecover(c.Name, entryName, FAIL, curSuccessRateStatus.recentRecoverOutput)
-				}
-				// 重置标志
-				curSuccessRateStatus.curState = NONE
-				curSuccessRateStatus.recentAlertOutput = curSuccessRateStatus.recentAlertOutput[:0]
-			}
+		*recentOutput = append(*recentOutput, outputData)
+		return len(*recentOutput)
+	}
+	if window < need {
+		window = need
+	}
+	*recentOutput = append(*recentOutput, outputData)
+	s.recentHits = append(s.recentHits, hit)
+	if n := len(s.recentHits); n > window {
+		*recentOutput = append((*recentOutput)[:0], (*recentOutput)[n - window:]...)
+		s.recentHits = append(s.recentHits[:0], s.recentHits[n - window:]...)
+	}
+	hits := 0
+	for _, h := range s.recentHits {
+		if h {
+			hits++
 		}
 	}
-}
\ No newline at end of file
+	return hits
+}
+
+// 不达标周期中的第一个，即告警的开始，连续模式下每个周期都不达标
+func (s *alertStatus) firstHit() OutPutData {
+	for i, h := range s.recentHits {
+		if h {
+			return s.recentAlertOutput[i]
+		}
+	}
+	return s.recentAlertOutput[0]
+}
+
+// 发出告警、重复或升级通知，处于静默或抖动中时暂不发出
+func (c *ReportClientConfig) notifyAlert(status *alertStatus, alertType AlertType, entryName string, eventType EventType, recentOutputData []OutPutData, now time.Time) {
+	if status.flapping || c.silenced(entryName, alertType, now) {
+		return
+	}
+	status.notified = true
+	status.notifiedAt = now
+	if eventType == ESCALATE {
+		status.escalated = true
+	}
+	c.dispatch(c.newAlertEvent(status, alertType, entryName, eventType, recentOutputData, now))
+}
+
+// 发出恢复通知，告警从未发出（一直处于静默中）时无需恢复，处于静默中时留待静默结束之后补发，使告警与恢复通知始终成对
+func (c *ReportClientConfig) notifyResolved(status *alertStatus, alertType AlertType, entryName string, recentOutputData []OutPutData, now time.Time) {
+	if !status.notified {
+		return
+	}
+	status.notified = false
+	e := c.newAlertEvent(status, alertType, entryName, RESOLVED, recentOutputData, now)
+	if c.silenced(entryName, alertType, now) {
+		status.pendingResolved = e
+		return
+	}
+	c.dispatch(e)
+}
+
+// 根据告警状态生成告警事件
+func (c *ReportClientConfig) newAlertEvent(status *alertStatus, alertType AlertType, entryName string, eventType EventType, recentOutputData []OutPutData, now time.Time) *AlertEvent {
+	e := &AlertEvent {
+		ClientName: c.Name,
+		InterfaceName: entryName,
+		Labels: c.Labels,
+		AlertType: alertType,
+		EventType: eventType,
+		StartsAt: status.startsAt,
+		Time: now,
+		Duration: now.Sub(status.startsAt),
+		Severity: status.severity,
+		Escalated: status.escalated,
+		RecentOutputData: append([]OutPutData {}, recentOutputData...),
+	}
+	if eventType == SEVERITY_CHANGED {
+		e.PrevSeverity = status.prevSeverity
+	} else if eventType == RESOLVED {
+		// 恢复之后不再处于任何告警级别，恢复之前的级别记录在PrevSeverity中
+		e.PrevSeverity = status.severity
+		e.Severity = NORMAL
+	}
+	return e
+}
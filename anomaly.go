@@ -0,0 +1,187 @@
+package monitor
+
+import (
+	"math"
+	"time"
+)
+
+// 条目的异常检测配置，以历史统计数据学习基线，当前周期偏离基线过多时视为异常
+type AnomalyConfig struct {
+	// 偏离基线多少个标准差视为异常，默认3
+	Deviation float64 `json:"deviation"`
+	// EWMA基线的平滑系数，越大越偏重近期数据，默认0.1
+	Alpha float64 `json:"alpha"`
+	// 季节周期，例如24小时，设置后将按时间段分别学习基线，以适应一天中不同时段的差异，默认为0即只使用整体基线
+	Season time.Duration `json:"season"`
+	// 季节周期内每个时间段的长度，默认1小时
+	SeasonSlot time.Duration `json:"seasonSlot"`
+	// 基线至少学习多少个周期才开始检测，默认30，季节基线的每个时间段同理，未学习完成的时间段将使用整体基线
+	WarmupCycles int `json:"warmupCycles"`
+	// 连续多少个统计周期异常发出告警，默认3
+	AlertTimes int `json:"alertTimes"`
+	// 告警之后连续多少个统计周期正常发出恢复报告，默认3
+	RecoverTimes int `json:"recoverTimes"`
+}
+
+// 输出数据中携带的异常检测结果
+type AnomalyData struct {
+	// 是否存在异常指标
+	Anomalous bool `json:"anomalous"`
+	// 各指标的检测结果
+	Metrics []AnomalyMetric `json:"metrics"`
+}
+
+// 单个指标的检测结果
+type AnomalyMetric struct {
+	// 指标名称，取值为successMsAver、fastRate、successRate
+	Name string `json:"name"`
+	// 当前值
+	Value float64 `json:"value"`
+	// 基线均值
+	Mean float64 `json:"mean"`
+	// 基线标准差
+	StdDev float64 `json:"stdDev"`
+	// 偏离基线的标准差个数，正数表示高于基线
+	Deviation float64 `json:"deviation"`
+	// 是否异常，耗时只检测升高，成功率与高效访问率只检测降低
+	Anomalous bool `json:"anomalous"`
+}
+
+// 单个指标的EWMA基线
+type ewmaBaseline struct {
+	mean float64
+	variance float64
+	count int
+}
+
+// 条目的异常检测器，只在统计分析模块中读写
+type anomalyDetector struct {
+	// 整体基线，按指标名称存储
+	baselines map[string]*ewmaBaseline
+	// 季节基线，按时间段序号和指标名称存储
+	seasonBaselines map[int64]map[string]*ewmaBaseline
+}
+
+// 规范化异常检测配置，不合法时返回ConfigError
+func normalizeAnomalyConfig(anomaly *AnomalyConfig) (*AnomalyConfig, error) {
+	if anomaly == nil {
+		return nil, nil
+	}
+	a := *anomaly
+	if a.Deviation <= 0 {
+		a.Deviation = 3
+	}
+	if a.Alpha <= 0 || a.Alpha >= 1 {
+		a.Alpha = 0.1
+	}
+	if a.Season < 0 {
+		a.Season = 0
+	}
+	if a.SeasonSlot <= 0 {
+		a.SeasonSlot = time.Hour
+	}
+	if a.Season > 0 && a.Season < a.SeasonSlot {
+		return nil, &ConfigError {Key: "anomaly.season", Message: "季节周期不能小于时间段的长度"}
+	}
+	if a.WarmupCycles <= 0 {
+		a.WarmupCycles = 30
+	}
+	if a.AlertTimes <= 0 {
+		a.AlertTimes = 3
+	}
+	if a.RecoverTimes <= 0 {
+		a.RecoverTimes = 3
+	}
+	return &a, nil
+}
+
+// 将一个周期的值计入基线
+func (b *ewmaBaseline) observe(value float64, alpha float64) {
+	if b.count == 0 {
+		b.mean = value
+	} else {
+		diff := value - b.mean
+		incr := alpha * diff
+		b.mean += incr
+		b.variance = (1 - alpha) * (b.variance + diff * incr)
+	}
+	b.count++
+}
+
+// 检测本周期的输出数据并更新基线，所有指标都仍在预热时返回nil
+func (d *anomalyDetector) detect(o *OutPutData, config *AnomalyConfig) *AnomalyData {
+	if d.baselines == nil {
+		d.baselines = map[string]*ewmaBaseline {}
+		d.seasonBaselines = map[int64]map[string]*ewmaBaseline {}
+	}
+	var season map[string]*ewmaBaseline
+	if config.Season > 0 {
+		slot := int64(o.Timestamp.UnixNano() % int64(config.Season) / int64(config.SeasonSlot))
+		if season = d.seasonBaselines[slot]; season == nil {
+			season = map[string]*ewmaBaseline {}
+			d.seasonBaselines[slot] = season
+		}
+	}
+	result := &AnomalyData {Metrics: make([]AnomalyMetric, 0, 3)}
+	check := func(name string, value float64, higherIsBad bool, minStdDev float64, minRelativeStdDev float64) {
+		baseline := d.baselines[name]
+		if baseline == nil {
+			baseline = &ewmaBaseline {}
+			d.baselines[name] = baseline
+		}
+		// 季节基线学习完成时优先使用季节基线
+		use := baseline
+		var seasonBaseline *ewmaBaseline
+		if season != nil {
+			seasonBaseline = season[name]
+			if seasonBaseline == nil {
+				seasonBaseline = &ewmaBaseline {}
+				season[name] = seasonBaseline
+			}
+			if seasonBaseline.count >= config.WarmupCycles {
+				use = seasonBaseline
+			}
+		}
+		// 计入基线的值，异常时将截断到阈值边界，使基线能够缓慢适应水平的变化，又不至于被一次异常拉大方差
+		observed := value
+		defer func() {
+			baseline.observe(observed, config.Alpha)
+			if seasonBaseline != nil {
+				seasonBaseline.observe(observed, config.Alpha)
+			}
+		}()
+		if use.count < config.WarmupCycles {
+			return
+		}
+		// 标准差设置下限，避免长期稳定的指标出现微小波动即被判定为异常
+		stdDev := math.Max(math.Sqrt(use.variance), math.Max(minStdDev, math.Abs(use.mean) * minRelativeStdDev))
+		m := AnomalyMetric {
+			Name: name,
+			Value: value,
+			Mean: use.mean,
+			StdDev: stdDev,
+			Deviation: (value - use.mean) / stdDev,
+		}
+		if higherIsBad {
+			m.Anomalous = m.Deviation > config.Deviation
+		} else {
+			m.Anomalous = -m.Deviation > config.Deviation
+		}
+		if m.Anomalous {
+			observed = use.mean + math.Copysign(config.Deviation * stdDev, m.Deviation)
+		}
+		result.Anomalous = result.Anomalous || m.Anomalous
+		result.Metrics = append(result.Metrics, m)
+	}
+	// 没有成功调用的周期不存在耗时
+	if o.SuccessCount > 0 {
+		check("successMsAver", float64(o.SuccessMsAver), true, 1, 0.05)
+	}
+	check("fastRate", o.FastRate, false, 0.01, 0)
+	check("successRate", o.SuccessRate, false, 0.01, 0)
+	// 所有指标都仍在预热时数据不足，不输出检测结果
+	if len(result.Metrics) == 0 {
+		return nil
+	}
+	return result
+}
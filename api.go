@@ -0,0 +1,234 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 接口返回的客户端信息
+type apiClient struct {
+	// 客户端命名
+	Name string `json:"name"`
+	// 客户端的标签
+	Labels map[string]string `json:"labels,omitempty"`
+	// 统计周期，单位ms
+	StatisticalCycle int `json:"statisticalCycle"`
+	// 保留了统计数据的条目数
+	Entries int `json:"entries"`
+	// 当前的告警数
+	Alerts int `json:"alerts"`
+}
+
+// 接口返回的条目信息
+type apiEntry struct {
+	// 接口命名
+	Name string `json:"name"`
+	// 最近一个周期的统计数据
+	Latest *OutPutData `json:"latest,omitempty"`
+	// 当前的告警
+	Alerts []ActiveAlert `json:"alerts"`
+}
+
+// 接口返回的错误信息
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// 查询接口，列出一个注册表中的客户端
+type apiHandler struct {
+	// 接口的路径前缀，不以/结尾
+	prefix string
+	registry *Registry
+}
+
+// 以JSON形式提供默认注册表中客户端状态的查询接口，prefix为接口的路径前缀，例如"/monitor/api"，各接口如下
+//   GET    {prefix}/clients                              全部已注册的客户端
+//   GET    {prefix}/clients/{client}/entries             客户端的条目，附带最近一个周期的统计数据与当前告警
+//   GET    {prefix}/clients/{client}/latest?entry=       条目最近一个周期的统计数据
+//   GET    {prefix}/clients/{client}/history?entry=&from=&to=  条目保留的统计数据，from与to为RFC3339格式的时间，可省略
+//   GET    {prefix}/clients/{client}/config?entry=       条目实际生效的配置
+//   PUT    {prefix}/clients/{client}/config              更新告警阈值与条目配置，请求体为ConfigUpdate，返回发生变化的配置项
+//   GET    {prefix}/clients/{client}/alerts              客户端当前的告警
+//   GET    {prefix}/clients/{client}/silences            客户端尚未结束的静默规则
+//   POST   {prefix}/clients/{client}/silences            添加静默规则，请求体为Silence，返回添加后的静默规则
+//   DELETE {prefix}/clients/{client}/silences/{id}       删除静默规则
+// 客户端名称需经过url编码，出错时返回对应的状态码以及{"error": "..."}
+func APIHandler(prefix string) http.Handler {
+	return DefaultRegistry.APIHandler(prefix)
+}
+
+// 本注册表中客户端状态的查询接口，用法同APIHandler
+func (r *Registry) APIHandler(prefix string) http.Handler {
+	return &apiHandler {prefix: strings.TrimSuffix(prefix, "/"), registry: r}
+}
+
+// 按路径分发请求
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, h.prefix + "/") {
+		writeAPIError(w, http.StatusNotFound, "接口不存在")
+		return
+	}
+	var segments []string
+	for _, segment := range strings.Split(strings.Trim(path[len(h.prefix):], "/"), "/") {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "路径格式错误")
+			return
+		}
+		segments = append(segments, segment)
+	}
+	if segments[0] != "clients" {
+		writeAPIError(w, http.StatusNotFound, "接口不存在")
+		return
+	}
+	if len(segments) == 1 {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+			return
+		}
+		h.clients(w)
+		return
+	}
+	client := h.registry.lookup(segments[1])
+	if client == nil {
+		writeAPIError(w, http.StatusNotFound, "客户端不存在")
+		return
+	}
+	if len(segments) == 2 {
+		writeAPIError(w, http.StatusNotFound, "接口不存在")
+		return
+	}
+	route := r.Method + " " + strings.Join(segments[2:], "/")
+	if strings.HasPrefix(route, "DELETE silences/") && len(segments) == 4 {
+		if !client.Unsilence(segments[3]) {
+			writeAPIError(w, http.StatusNotFound, "静默规则不存在")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	switch route {
+	case "GET entries":
+		client.apiEntries(w)
+	case "GET latest":
+		latest, ok := client.Latest(r.URL.Query().Get("entry"))
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "条目不存在")
+			return
+		}
+		writeAPIData(w, http.StatusOK, latest)
+	case "GET history":
+		client.apiHistory(w, r)
+	case "GET config":
+		writeAPIData(w, http.StatusOK, client.EffectiveEntryConfig(r.URL.Query().Get("entry")))
+	case "PUT config":
+		client.apiUpdateConfig(w, r)
+	case "GET alerts":
+		writeAPIData(w, http.StatusOK, client.Alerts())
+	case "GET silences":
+		writeAPIData(w, http.StatusOK, client.Silences())
+	case "POST silences":
+		client.apiSilence(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, "接口不存在")
+	}
+}
+
+// 列出全部客户端
+func (h *apiHandler) clients(w http.ResponseWriter) {
+	clients := []apiClient {}
+	for _, c := range h.registry.registered() {
+		clients = append(clients, apiClient {
+			Name: c.Name,
+			Labels: c.Labels,
+			StatisticalCycle: c.StatisticalCycle,
+			Entries: len(c.Entries()),
+			Alerts: len(c.Alerts()),
+		})
+	}
+	writeAPIData(w, http.StatusOK, clients)
+}
+
+// 列出客户端的条目
+func (c *ReportClientConfig) apiEntries(w http.ResponseWriter) {
+	alerts := c.Alerts()
+	entries := []apiEntry {}
+	for _, name := range c.Entries() {
+		entry := apiEntry {Name: name, Alerts: []ActiveAlert {}}
+		if latest, ok := c.Latest(name); ok {
+			entry.Latest = &latest
+		}
+		for _, alert := range alerts {
+			if alert.InterfaceName == name {
+				entry.Alerts = append(entry.Alerts, alert)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	writeAPIData(w, http.StatusOK, entries)
+}
+
+// 查询条目保留的统计数据
+func (c *ReportClientConfig) apiHistory(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	var err error
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "from格式错误：" + err.Error())
+			return
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "to格式错误：" + err.Error())
+			return
+		}
+	}
+	writeAPIData(w, http.StatusOK, c.History(r.URL.Query().Get("entry"), from, to))
+}
+
+// 添加静默规则
+func (c *ReportClientConfig) apiSilence(w http.ResponseWriter, r *http.Request) {
+	var s Silence
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "请求体格式错误：" + err.Error())
+		return
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		writeAPIError(w, http.StatusBadRequest, "静默的结束时间必须晚于开始时间")
+		return
+	}
+	s.ID = c.Silence(s)
+	writeAPIData(w, http.StatusCreated, s)
+}
+
+// 更新配置
+func (c *ReportClientConfig) apiUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	var u ConfigUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "请求体格式错误：" + err.Error())
+		return
+	}
+	changes, err := c.UpdateConfig(u)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeAPIData(w, http.StatusOK, changes)
+}
+
+// 输出JSON数据
+func writeAPIData(w http.ResponseWriter, status int, data interface {}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// 输出错误信息
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIData(w, status, apiError {Error: message})
+}
@@ -0,0 +1,25 @@
+package monitor
+
+import "time"
+
+// 时钟，客户端通过它计时统计周期与获取当前时间，测试时可以替换为手动推进的时钟
+type Clock interface {
+	// 当前时间
+	Now() time.Time
+	// 创建一个每隔d触发一次的定时器，返回接收触发时间的通道以及停止定时器的函数
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// 系统时钟
+type systemClock struct {}
+
+// 当前时间
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 创建定时器
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
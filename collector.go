@@ -24,23 +24,53 @@ type reportData struct {
 	TimeConsumingDistribution []uint32
 	// 条目的配置
 	Config *EntryConfig
+	// 本周期生效的告警阈值
+	thresholds *Thresholds
 	// 本次统计的时间
 	Time time.Time
+	// 连续没有上报数据的周期数
+	idleCycles int
+	// 条目已被移除，统计分析与告警分析模块据此清理条目的状态
+	evicted bool
 }
 
 // 条目统计相关的更详尽配置
 type EntryConfig struct {
 	// 时间达标的最大耗时，默认为500ms
-	FastLessThan uint32
+	FastLessThan uint32 `json:"fastLessThan"`
 	// 耗时分布区间个数，默认为10，至少为3，最多为20
 	// 第一个区间用于标注小于TimeConsumingMin的个数
 	// 最后一个区间用于标注大于TimeConsumingMax的个数
 	// 剩余的(TimeConsumingDistributionSplit - 2)个区间，范围为(最大耗时-最小耗时)/(区间数-2)
-	TimeConsumingDistributionSplit int
+	TimeConsumingDistributionSplit int `json:"timeConsumingDistributionSplit"`
 	// 耗时分布区间计最大耗时，默认为500ms
-	TimeConsumingDistributionMax uint32
+	TimeConsumingDistributionMax uint32 `json:"timeConsumingDistributionMax"`
 	// 耗时分布区间计最小耗时，默认为50ms，至少为1
-	TimeConsumingDistributionMin uint32
+	TimeConsumingDistributionMin uint32 `json:"timeConsumingDistributionMin"`
+	// 一个统计周期内的最少调用次数，少于该值时标记为数据不足，默认为0即沿用客户端的MinRequestCount
+	MinRequestCount int `json:"minRequestCount"`
+	// 成功率多少以上算通过，默认为0即沿用客户端的SuccessRate
+	SuccessRate float64 `json:"successRate"`
+	// 高效访问率多少以上算通过，默认为0即沿用客户端的FastRate
+	FastRate float64 `json:"fastRate"`
+	// 成功率的警告阈值，默认为0即沿用客户端的WarningSuccessRate
+	WarningSuccessRate float64 `json:"warningSuccessRate"`
+	// 高效访问率的警告阈值，默认为0即沿用客户端的WarningFastRate
+	WarningFastRate float64 `json:"warningFastRate"`
+	// 成功率连续不达标多少个统计周期发出告警，默认为0即沿用客户端的配置，以下三项同理
+	AlertForBadSuccessRateReachedTimes int `json:"alertForBadSuccessRateReachedTimes"`
+	// 耗时连续不达标多少个统计周期发出告警
+	AlertForBadFastRateReachedTimes int `json:"alertForBadFastRateReachedTimes"`
+	// 成功率连续达标多少个统计周期发出恢复报告
+	AlertForGreatSuccessRateReachedTimes int `json:"alertForGreatSuccessRateReachedTimes"`
+	// 耗时连续达标多少个统计周期发出恢复报告
+	AlertForGreatFastRateReachedTimes int `json:"alertForGreatFastRateReachedTimes"`
+	// 滑动窗口的周期数，默认为0即沿用客户端的AlertWindow，设置为1即相当于连续模式
+	AlertWindow int `json:"alertWindow"`
+	// 条目的SLO定义，为nil时不跟踪错误预算
+	SLO *SLOConfig `json:"slo,omitempty"`
+	// 条目的异常检测配置，为nil时不启用
+	Anomaly *AnomalyConfig `json:"anomaly,omitempty"`
 	// 计算出区间
 	timeConsumingRange uint32
 }
@@ -56,14 +86,49 @@ var defaultEntryConfig = &EntryConfig {
 
 // 统一通过此方法获取条目的配置，数据变得规范
 func (c *ReportClientConfig) getEntryConfig(name string) *EntryConfig {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
 	if curEntryConfig, ok := c.entryConfigMap[name]; ok {
 		return &curEntryConfig
 	}
 	return defaultEntryConfig
 }
 
-// 添加条目的自定义属性
+// 条目实际生效的配置，条目未设置的告警阈值、周期数与最少调用次数以客户端的配置补全
+// 运行时更新的配置立即体现在这里，但要到下一个统计周期开始时才用于统计与告警分析
+func (c *ReportClientConfig) EffectiveEntryConfig(name string) EntryConfig {
+	config := *c.getEntryConfig(name)
+	thresholds := c.currentThresholds()
+	rule := c.alertRule(&config, thresholds)
+	config.SuccessRate = rule.SuccessRate
+	config.FastRate = rule.FastRate
+	config.WarningSuccessRate = rule.WarningSuccessRate
+	config.WarningFastRate = rule.WarningFastRate
+	config.AlertForBadSuccessRateReachedTimes = rule.AlertForBadSuccessRateReachedTimes
+	config.AlertForBadFastRateReachedTimes = rule.AlertForBadFastRateReachedTimes
+	config.AlertForGreatSuccessRateReachedTimes = rule.AlertForGreatSuccessRateReachedTimes
+	config.AlertForGreatFastRateReachedTimes = rule.AlertForGreatFastRateReachedTimes
+	config.AlertWindow = rule.AlertWindow
+	if config.MinRequestCount <= 0 {
+		config.MinRequestCount = thresholds.MinRequestCount
+	}
+	return config
+}
+
+// 添加条目的自定义属性，已经有上报数据的条目在下一个统计周期开始时生效
 func (c *ReportClientConfig) AddEntryConfig(name string, entryConfig EntryConfig) {
+	entryConfig, err := normalizeEntryConfig(entryConfig)
+	if err != nil {
+		panic(err.(*ConfigError).Message)
+	}
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	c.entryConfigMap[name] = entryConfig
+	c.pendingEntries[name] = true
+}
+
+// 规范化条目配置，不合法时返回ConfigError，Key为出错的配置项在条目配置中的路径
+func normalizeEntryConfig(entryConfig EntryConfig) (EntryConfig, error) {
 	if entryConfig.FastLessThan <= 0 {
 		entryConfig.FastLessThan = 500
 	}
@@ -78,16 +143,49 @@ func (c *ReportClientConfig) AddEntryConfig(name string, entryConfig EntryConfig
 		entryConfig.TimeConsumingDistributionMin = 50
 	}
 	if entryConfig.TimeConsumingDistributionMax <= entryConfig.TimeConsumingDistributionMin {
-		panic("耗时最长值必须大于耗时最短值")
+		return entryConfig, &ConfigError {Key: "timeConsumingDistributionMax", Message: "耗时最长值必须大于耗时最短值"}
+	}
+	// 每个中间区间至少跨越1ms，否则区间跨度为0
+	if entryConfig.TimeConsumingDistributionMax - entryConfig.TimeConsumingDistributionMin < uint32(entryConfig.TimeConsumingDistributionSplit - 2) {
+		return entryConfig, &ConfigError {Key: "timeConsumingDistributionMax", Message: "耗时最长值与最短值之差不能小于区间个数减2"}
+	}
+	if err := validateRates(entryConfig.SuccessRate, entryConfig.FastRate, entryConfig.WarningSuccessRate, entryConfig.WarningFastRate); err != nil {
+		return entryConfig, err
+	}
+	var err error
+	if entryConfig.SLO, err = normalizeSLOConfig(entryConfig.SLO); err != nil {
+		return entryConfig, err
+	}
+	if entryConfig.Anomaly, err = normalizeAnomalyConfig(entryConfig.Anomaly); err != nil {
+		return entryConfig, err
 	}
 	entryConfig.timeConsumingRange = (entryConfig.TimeConsumingDistributionMax - entryConfig.TimeConsumingDistributionMin) / uint32(entryConfig.TimeConsumingDistributionSplit - 2)
-	c.entryConfigMap[name] = entryConfig
+	return entryConfig, nil
+}
+
+// 校验成功率与高效访问率阈值，第一个不在0和1之间的阈值作为错误返回
+func validateRates(successRate float64, fastRate float64, warningSuccessRate float64, warningFastRate float64) error {
+	keys := []string {"successRate", "fastRate", "warningSuccessRate", "warningFastRate"}
+	for i, rate := range []float64 {successRate, fastRate, warningSuccessRate, warningFastRate} {
+		if rate < 0 || rate > 1 {
+			return &ConfigError {Key: keys[i], Message: "成功率与高效访问率阈值必须介于0和1之间"}
+		}
+	}
+	return nil
 }
 
 // 收集
 func (c *ReportClientConfig) collect() {
+	// 客户端关闭后通知统计分析模块退出
+	defer close(c.statisticsChannel)
 	// 监听本客户端的上报信道
-	for t := range c.taskChannel {
+	for {
+		var t *taskQueue
+		select {
+		case t = <-c.taskChannel:
+		case <-c.done:
+			return
+		}
 		// 服务端上报类型的统计任务
 		if t.taskType == SERVER {
 			curReportServerData := t.data.(reportServer)
@@ -96,29 +194,52 @@ func (c *ReportClientConfig) collect() {
 		} else if t.taskType == CLEAR {		// 清理旧统计数据的任务
 			curClearData := t.data.(clearData)
 			c.clearTask(&curClearData)
+		} else if t.taskType == SNAPSHOT {		// 拷贝当前周期数据的任务
+			curSnapshotData := t.data.(snapshotData)
+			c.snapshotTask(&curSnapshotData)
 		}
 	}
 }
 
 // 清理任务
 func (c *ReportClientConfig) clearTask(curClearData *clearData) {
-	curCollectData := c.collectDataMap[curClearData.Name]
-	// 只在有上报记录时才做清理
-	if curCollectData.SuccessCount != 0 || curCollectData.FailCount != 0 {
+	// 汇总条目需要在清空之前计算
+	for _, rollupData := range c.rollupData(curClearData.Time) {
+		c.statisticsChannel <- rollupData
+	}
+	for name, curCollectData := range c.collectDataMap {
 		collectedData := *curCollectData
 		collectedData.Time = curClearData.Time
-		// 拷贝一份数据流入分析
+		collectedData.thresholds = c.cycleThresholds
+		if curCollectData.SuccessCount != 0 || curCollectData.FailCount != 0 {
+			curCollectData.idleCycles = 0
+		} else {
+			curCollectData.idleCycles++
+		}
+		if c.EntryRetentionCycles > 0 && curCollectData.idleCycles > c.AlertForNoDataReachedTimes + c.EntryRetentionCycles {
+			// 超过保留的周期数之后移除条目，并通知后续模块清理条目的状态
+			delete(c.collectDataMap, name)
+			collectedData.evicted = true
+			c.statisticsChannel <- collectedData
+			continue
+		}
+		// 拷贝一份数据流入分析，没有上报记录的条目同样需要流入，以支持无数据和调用量下降的告警
 		c.statisticsChannel <- collectedData
-		// 清空旧数据
-		curCollectData.MinMs = 0
-		curCollectData.MaxMs = 0
-		curCollectData.FailCount = 0
-		curCollectData.SuccessCount = 0
-		curCollectData.SuccessMsCount = 0
-		curCollectData.FastCount = 0
-		curCollectData.FailDistribution = map[int]uint32 {}
-		curCollectData.TimeConsumingDistribution = make([]uint32, curCollectData.Config.TimeConsumingDistributionSplit)
+		// 只在有上报记录时才做清理
+		if curCollectData.SuccessCount != 0 || curCollectData.FailCount != 0 {
+			// 清空旧数据
+			curCollectData.MinMs = 0
+			curCollectData.MaxMs = 0
+			curCollectData.FailCount = 0
+			curCollectData.SuccessCount = 0
+			curCollectData.SuccessMsCount = 0
+			curCollectData.FastCount = 0
+			curCollectData.FailDistribution = map[int]uint32 {}
+			curCollectData.TimeConsumingDistribution = make([]uint32, curCollectData.Config.TimeConsumingDistributionSplit)
+		}
 	}
+	// 数据清空之后应用本周期内更新的配置
+	c.applyConfig()
 }
 
 // 服务端上报类型的收集任务
@@ -156,16 +277,7 @@ func (c *ReportClientConfig) serverTask(curReportServerData *reportServer) {
 			curCollectData.MaxMs = curReportServerData.Ms
 		}
 		curCollectData.SuccessMsCount += uint64(curReportServerData.Ms)
-		// 耗时小于区间最小  归类为第一区间
-		if curReportServerData.Ms < curCollectData.Config.TimeConsumingDistributionMin {
-			curCollectData.TimeConsumingDistribution[0] += 1
-		} else if curReportServerData.Ms >= curCollectData.Config.TimeConsumingDistributionMax {
-			// 耗时大于等于区间最大  归类为最后一个区间
-			curCollectData.TimeConsumingDistribution[curCollectData.Config.TimeConsumingDistributionSplit - 1] += 1
-		} else {
-			// 其他情况落在对应的耗时区间
-			curCollectData.TimeConsumingDistribution[(curReportServerData.Ms - curCollectData.Config.TimeConsumingDistributionMin) / curCollectData.Config.timeConsumingRange + 1] += 1
-		}
+		curCollectData.TimeConsumingDistribution[curCollectData.Config.distributionIndex(curReportServerData.Ms)] += 1
 		if curReportServerData.Ms <= curCollectData.Config.FastLessThan {
 			curCollectData.FastCount++
 		}
@@ -173,4 +285,33 @@ func (c *ReportClientConfig) serverTask(curReportServerData *reportServer) {
 		curCollectData.FailCount++
 		curCollectData.FailDistribution[curReportServerData.Code]++
 	}
-}
\ No newline at end of file
+}
+
+// 耗时所在的时延分布区间
+func (e *EntryConfig) distributionIndex(ms uint32) int {
+	// 耗时小于区间最小  归类为第一区间
+	if ms < e.TimeConsumingDistributionMin {
+		return 0
+	}
+	// 耗时大于等于区间最大  归类为最后一个区间
+	if ms >= e.TimeConsumingDistributionMax {
+		return e.TimeConsumingDistributionSplit - 1
+	}
+	// 其他情况落在对应的耗时区间，区间跨度取整之后余下的部分归入最后一个中间区间
+	index := int((ms - e.TimeConsumingDistributionMin) / e.timeConsumingRange + 1)
+	if index > e.TimeConsumingDistributionSplit - 2 {
+		index = e.TimeConsumingDistributionSplit - 2
+	}
+	return index
+}
+
+// 时延分布区间的起点
+func (e *EntryConfig) distributionStart(i int) uint32 {
+	if i == 0 {
+		return 0
+	}
+	if i == e.TimeConsumingDistributionSplit - 1 {
+		return e.TimeConsumingDistributionMax
+	}
+	return e.TimeConsumingDistributionMin + uint32(i - 1) * e.timeConsumingRange
+}
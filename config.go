@@ -0,0 +1,790 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 从配置文件创建客户端，配置文件支持JSON与YAML格式，YAML只支持常用的子集，以免引入第三方依赖
+// 告警管理器的路由与抑制规则可以在配置文件中声明，回调函数与接收者等无法声明的内容通过名称引用，由加载器提供，名称"console"表示默认的输出到控制台
+type ConfigLoader struct {
+	// 统计数据的输出，供output与resolutionOutput引用
+	Outputs map[string]func(o *OutPutData)
+	// 告警通知，供alert、recover、escalation与flapping引用
+	Alerts map[string]func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
+	// 告警事件的处理，供event与severityCallers引用
+	Events map[string]func(e *AlertEvent)
+	// 告警管理器，供alertManager引用，配置文件中声明的告警管理器不能与其重名
+	AlertManagers map[string]*AlertManager
+	// 告警管理器的接收者，供配置文件中声明的告警管理器引用
+	Receivers map[string]Receiver
+	// 状态码的判定方式，供codeFeature引用
+	CodeFeatures map[string]func(code int) (success bool, name string)
+	// 客户端注册到哪个注册表，默认为DefaultRegistry
+	Registry *Registry
+	// 监听配置文件时重新加载的结果处理，默认将发生变化的配置项输出到控制台
+	ReloadCaller func(changes []ConfigChange, err error)
+	// 保护entryNames，重新加载可能与加载同时进行
+	lock sync.Mutex
+	// 各个客户端从配置文件中加载的条目名称
+	entryNames map[string][]string
+}
+
+// 配置文件的错误，Key指出出错的配置项，例如clients[0].entries["GET - /api/users"].successRate
+type ConfigError struct {
+	Key string
+	Message string
+}
+
+// 错误信息
+func (e *ConfigError) Error() string {
+	if e.Key == "" {
+		return e.Message
+	}
+	return e.Key + ": " + e.Message
+}
+
+// 配置文件的内容
+type fileConfig struct {
+	// 声明的告警管理器，以名称为键，供客户端的alertManager引用
+	AlertManagers map[string]json.RawMessage `json:"alertManagers"`
+	Clients []json.RawMessage `json:"clients"`
+}
+
+// 配置文件中的告警管理器，各项含义同AlertManager，接收者通过名称引用
+type alertManagerFileConfig struct {
+	Routes []json.RawMessage `json:"routes"`
+	DefaultReceiver string `json:"defaultReceiver"`
+	GroupBy []string `json:"groupBy"`
+	GroupWait string `json:"groupWait"`
+	GroupInterval string `json:"groupInterval"`
+	InhibitRules []json.RawMessage `json:"inhibitRules"`
+}
+
+// 配置文件中的路由规则
+type routeFileConfig struct {
+	ClientName string `json:"clientName"`
+	EntryPattern string `json:"entryPattern"`
+	AlertTypes []string `json:"alertTypes"`
+	Severities []string `json:"severities"`
+	Receiver string `json:"receiver"`
+	Continue bool `json:"continue"`
+	Routes []json.RawMessage `json:"routes"`
+}
+
+// 配置文件中的抑制规则
+type inhibitRuleFileConfig struct {
+	Source json.RawMessage `json:"source"`
+	Target json.RawMessage `json:"target"`
+	Equal []string `json:"equal"`
+}
+
+// 配置文件中告警的选择条件
+type alertSelectorFileConfig struct {
+	ClientName string `json:"clientName"`
+	EntryPattern string `json:"entryPattern"`
+	AlertTypes []string `json:"alertTypes"`
+	Severities []string `json:"severities"`
+}
+
+// 配置文件中的客户端配置，各项含义同ReportClientConfig，时长以"500ms"、"5m"、"1h"等字符串表示
+type clientFileConfig struct {
+	Name string `json:"name"`
+	Labels map[string]string `json:"labels"`
+	DefaultFastTime uint32 `json:"defaultFastTime"`
+	StatisticalCycle string `json:"statisticalCycle"`
+	AlertForBadSuccessRateReachedTimes int `json:"alertForBadSuccessRateReachedTimes"`
+	AlertForBadFastRateReachedTimes int `json:"alertForBadFastRateReachedTimes"`
+	AlertForGreatSuccessRateReachedTimes int `json:"alertForGreatSuccessRateReachedTimes"`
+	AlertForGreatFastRateReachedTimes int `json:"alertForGreatFastRateReachedTimes"`
+	AlertWindow int `json:"alertWindow"`
+	SuccessRate float64 `json:"successRate"`
+	FastRate float64 `json:"fastRate"`
+	WarningSuccessRate float64 `json:"warningSuccessRate"`
+	WarningFastRate float64 `json:"warningFastRate"`
+	AlertForNoDataReachedTimes int `json:"alertForNoDataReachedTimes"`
+	EntryRetentionCycles int `json:"entryRetentionCycles"`
+	TrafficDropRate float64 `json:"trafficDropRate"`
+	TrafficBaselineCycles int `json:"trafficBaselineCycles"`
+	AlertForTrafficDropReachedTimes int `json:"alertForTrafficDropReachedTimes"`
+	MinRequestCount int `json:"minRequestCount"`
+	WilsonConfidence float64 `json:"wilsonConfidence"`
+	// 嵌套的配置均先保留原始内容，再逐个严格解码，使错误中的配置项路径精确到嵌套的对象
+	Rollups []json.RawMessage `json:"rollups"`
+	ChannelCacheCount int `json:"channelCacheCount"`
+	CodeFeatureMap map[int]json.RawMessage `json:"codeFeatureMap"`
+	CodeFeature string `json:"codeFeature"`
+	DefaultFailDistributionFormat string `json:"defaultFailDistributionFormat"`
+	Output string `json:"output"`
+	HistorySize int `json:"historySize"`
+	Resolutions []string `json:"resolutions"`
+	ResolutionOutput string `json:"resolutionOutput"`
+	Alert string `json:"alert"`
+	Recover string `json:"recover"`
+	RepeatInterval string `json:"repeatInterval"`
+	Repeat string `json:"repeat"`
+	EscalateAfter string `json:"escalateAfter"`
+	Escalation string `json:"escalation"`
+	Flapping string `json:"flapping"`
+	FlapThreshold int `json:"flapThreshold"`
+	FlapWindow string `json:"flapWindow"`
+	Event string `json:"event"`
+	SeverityCallers map[string]string `json:"severityCallers"`
+	AlertManager string `json:"alertManager"`
+	StateDir string `json:"stateDir"`
+	StateMaxAge string `json:"stateMaxAge"`
+	MaintenanceWindows []json.RawMessage `json:"maintenanceWindows"`
+	// 条目配置，以条目名称为键
+	Entries map[string]json.RawMessage `json:"entries"`
+}
+
+// 配置文件中的条目配置，各项含义同EntryConfig
+type entryFileConfig struct {
+	EntryConfig
+	SLO json.RawMessage `json:"slo"`
+	Anomaly json.RawMessage `json:"anomaly"`
+}
+
+// 配置文件中的SLO配置
+type sloFileConfig struct {
+	Availability float64 `json:"availability"`
+	Latency float64 `json:"latency"`
+	Window string `json:"window"`
+	BurnRateRules []json.RawMessage `json:"burnRateRules"`
+}
+
+// 配置文件中的燃烧率规则
+type burnRateRuleFileConfig struct {
+	LongWindow string `json:"longWindow"`
+	ShortWindow string `json:"shortWindow"`
+	BurnRate float64 `json:"burnRate"`
+	Severity string `json:"severity"`
+}
+
+// 配置文件中的异常检测配置
+type anomalyFileConfig struct {
+	Deviation float64 `json:"deviation"`
+	Alpha float64 `json:"alpha"`
+	Season string `json:"season"`
+	SeasonSlot string `json:"seasonSlot"`
+	WarmupCycles int `json:"warmupCycles"`
+	AlertTimes int `json:"alertTimes"`
+	RecoverTimes int `json:"recoverTimes"`
+}
+
+// 配置文件中的维护窗口，weekdays为"Monday"等星期的英文名称，start为相对当天零点的时长，location为时区名称
+type maintenanceWindowFileConfig struct {
+	EntryPattern string `json:"entryPattern"`
+	AlertTypes []string `json:"alertTypes"`
+	Weekdays []string `json:"weekdays"`
+	Start string `json:"start"`
+	Duration string `json:"duration"`
+	Location string `json:"location"`
+	Comment string `json:"comment"`
+}
+
+// 配置文件中告警类型与告警级别的名称
+var (
+	alertTypeNames = map[string]AlertType {
+		"FAIL": FAIL,
+		"SLOW": SLOW,
+		"FAIL_BUDGET": FAIL_BUDGET,
+		"SLOW_BUDGET": SLOW_BUDGET,
+		"NO_DATA": NO_DATA,
+		"TRAFFIC_DROP": TRAFFIC_DROP,
+		"ANOMALY": ANOMALY,
+	}
+	severityNames = map[string]Severity {
+		"WARNING": WARNING,
+		"CRITICAL": CRITICAL,
+	}
+)
+
+// 使用默认的加载器读取配置文件并创建其中的客户端
+func LoadConfig(path string) ([]ReportClient, error) {
+	return (&ConfigLoader {}).Load(path)
+}
+
+// 读取配置文件并创建其中的客户端，扩展名为.yaml或.yml时按YAML格式解析
+func (l *ConfigLoader) Load(path string) ([]ReportClient, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.Parse(data)
+}
+
+// 解析YAML格式的配置并创建其中的客户端，配置项与JSON格式相同
+func (l *ConfigLoader) ParseYAML(data []byte) ([]ReportClient, error) {
+	data, err := yamlToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return l.Parse(data)
+}
+
+// 读取配置文件，扩展名为.yaml或.yml时按YAML格式解析并转为JSON
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yamlToJSON(data)
+	}
+	return data, nil
+}
+
+// 解析配置并创建其中的客户端，配置有误时不创建任何客户端
+func (l *ConfigLoader) Parse(data []byte) ([]ReportClient, error) {
+	configs, entries, err := l.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if l.registry().lookup(configs[i].Name) != nil {
+			return nil, &ConfigError {Key: fmt.Sprintf("clients[%d].name", i), Message: "客户端名称已被注册：" + configs[i].Name}
+		}
+	}
+	clients := []ReportClient {}
+	for i := range configs {
+		client, err := l.build(fmt.Sprintf("clients[%d]", i), configs[i], entries[i])
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i := range configs {
+		l.recordEntries(configs[i].Name, entries[i])
+	}
+	return clients, nil
+}
+
+// 客户端注册到的注册表
+func (l *ConfigLoader) registry() *Registry {
+	if l.Registry == nil {
+		return DefaultRegistry
+	}
+	return l.Registry
+}
+
+// 解析并校验配置，得到每个客户端的配置及其条目配置
+func (l *ConfigLoader) parse(data []byte) ([]ReportClientConfig, []map[string]EntryConfig, error) {
+	file := fileConfig {}
+	if err := decodeConfig(data, "", &file); err != nil {
+		return nil, nil, err
+	}
+	p := &configParser {loader: l, alertManagers: map[string]*AlertManager {}}
+	for _, name := range sortedConfigKeys(file.AlertManagers) {
+		key := "alertManagers[" + strconv.Quote(name) + "]"
+		if _, ok := l.AlertManagers[name]; ok {
+			p.fail(key, "告警管理器名称重复：" + name)
+		}
+		manager := alertManagerFileConfig {}
+		if p.decode(file.AlertManagers[name], key, &manager) {
+			p.alertManagers[name] = p.alertManager(key, &manager)
+		}
+	}
+	if p.err != nil {
+		return nil, nil, p.err
+	}
+	configs := []ReportClientConfig {}
+	entries := []map[string]EntryConfig {}
+	names := map[string]bool {}
+	for i, raw := range file.Clients {
+		key := fmt.Sprintf("clients[%d]", i)
+		client := clientFileConfig {}
+		if err := decodeConfig(raw, key, &client); err != nil {
+			return nil, nil, err
+		}
+		if names[client.Name] {
+			p.fail(key + ".name", "客户端名称重复：" + client.Name)
+		}
+		names[client.Name] = true
+		configs = append(configs, p.client(key, &client))
+		entryConfigs := map[string]EntryConfig {}
+		for _, name := range sortedConfigKeys(client.Entries) {
+			entryKey := key + ".entries[" + strconv.Quote(name) + "]"
+			entry := entryFileConfig {}
+			if err := decodeConfig(client.Entries[name], entryKey, &entry); err != nil {
+				return nil, nil, err
+			}
+			entryConfigs[name] = p.entry(entryKey, &entry)
+		}
+		entries = append(entries, entryConfigs)
+		if p.err != nil {
+			return nil, nil, p.err
+		}
+	}
+	return configs, entries, nil
+}
+
+// 注册客户端并添加条目配置，名称已被注册时返回错误而不替代原有的客户端，校验之外的配置错误会在注册时panic，此时转为错误返回
+func (l *ConfigLoader) build(key string, config ReportClientConfig, entries map[string]EntryConfig) (client ReportClient, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if client != nil {
+				client.Close()
+			}
+			client, err = nil, &ConfigError {Key: key, Message: fmt.Sprint(r)}
+		}
+	}()
+	if client, err = l.registry().Register(config); err != nil {
+		e := err.(*ConfigError)
+		return nil, &ConfigError {Key: joinConfigKey(key, e.Key), Message: e.Message}
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	clientKey := key
+	for _, name := range names {
+		key = clientKey + ".entries[" + strconv.Quote(name) + "]"
+		client.AddEntryConfig(name, entries[name])
+	}
+	return client, nil
+}
+
+// 按名称排列的条目，使出错时报告的配置项是确定的
+func sortedConfigKeys(entries map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 严格解码一层配置，未知的配置项视为错误，错误中带上配置项的完整路径
+func decodeConfig(data []byte, key string, v interface {}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, column := configPosition(data, e.Offset)
+		return &ConfigError {Key: key, Message: fmt.Sprintf("第%d行第%d列格式错误：%s", line, column, e.Error())}
+	case *json.UnmarshalTypeError:
+		return &ConfigError {Key: joinConfigKey(key, e.Field), Message: "类型错误，应为" + e.Type.String()}
+	}
+	if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+		name, _ := strconv.Unquote(field)
+		return &ConfigError {Key: joinConfigKey(key, name), Message: "未知的配置项"}
+	}
+	return &ConfigError {Key: key, Message: err.Error()}
+}
+
+// 配置项是否存在，null视为不存在
+func configPresent(data json.RawMessage) bool {
+	return len(data) > 0 && string(data) != "null"
+}
+
+// 拼接配置项的路径
+func joinConfigKey(key string, field string) string {
+	if key == "" || field == "" {
+		return key + field
+	}
+	return key + "." + field
+}
+
+// 出错字符所在的行与列，offset为读到出错字符之后的偏移量
+func configPosition(data []byte, offset int64) (line int, column int) {
+	line, column = 1, 1
+	if offset > 0 {
+		offset--
+	}
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return
+}
+
+// 将配置文件中的配置转为实际的配置，只记录第一个错误
+type configParser struct {
+	loader *ConfigLoader
+	// 配置文件中声明的告警管理器
+	alertManagers map[string]*AlertManager
+	err *ConfigError
+}
+
+// 记录错误
+func (p *configParser) fail(key string, message string) {
+	if p.err == nil {
+		p.err = &ConfigError {Key: key, Message: message}
+	}
+}
+
+// 严格解码嵌套的配置，出错时记录错误并返回false
+func (p *configParser) decode(data json.RawMessage, key string, v interface {}) bool {
+	if err := decodeConfig(data, key, v); err != nil {
+		if p.err == nil {
+			p.err = err.(*ConfigError)
+		}
+		return false
+	}
+	return true
+}
+
+// 解析时长，为空时返回0
+func (p *configParser) duration(key string, s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		p.fail(key, "无法解析的时长：" + s)
+	}
+	return d
+}
+
+// 校验比例介于0和1之间
+func (p *configParser) rate(key string, rate float64) float64 {
+	if rate < 0 || rate > 1 {
+		p.fail(key, "必须介于0和1之间")
+	}
+	return rate
+}
+
+// 解析告警类型
+func (p *configParser) alertTypes(key string, names []string) []AlertType {
+	var alertTypes []AlertType
+	for i, name := range names {
+		alertType, ok := alertTypeNames[strings.ToUpper(name)]
+		if !ok {
+			p.fail(fmt.Sprintf("%s[%d]", key, i), "未知的告警类型：" + name)
+		}
+		alertTypes = append(alertTypes, alertType)
+	}
+	return alertTypes
+}
+
+// 解析告警级别
+func (p *configParser) severity(key string, name string) Severity {
+	if name == "" {
+		return NORMAL
+	}
+	severity, ok := severityNames[strings.ToUpper(name)]
+	if !ok {
+		p.fail(key, "未知的告警级别：" + name)
+	}
+	return severity
+}
+
+// 校验引用的名称已由加载器提供，为空或为"console"时使用默认行为
+func (p *configParser) reference(key string, kind string, name string, defined bool) {
+	if name != "" && name != "console" && !defined {
+		p.fail(key, "未定义的" + kind + "：" + name)
+	}
+}
+
+// 转换客户端配置
+func (p *configParser) client(key string, f *clientFileConfig) ReportClientConfig {
+	l := p.loader
+	if f.Name == "" {
+		p.fail(key + ".name", "必须为客户端指定一个名称")
+	}
+	c := ReportClientConfig {
+		Name: f.Name,
+		Labels: f.Labels,
+		DefaultFastTime: f.DefaultFastTime,
+		StatisticalCycle: int(p.duration(key + ".statisticalCycle", f.StatisticalCycle) / time.Millisecond),
+		AlertForBadSuccessRateReachedTimes: f.AlertForBadSuccessRateReachedTimes,
+		AlertForBadFastRateReachedTimes: f.AlertForBadFastRateReachedTimes,
+		AlertForGreatSuccessRateReachedTimes: f.AlertForGreatSuccessRateReachedTimes,
+		AlertForGreatFastRateReachedTimes: f.AlertForGreatFastRateReachedTimes,
+		AlertWindow: f.AlertWindow,
+		SuccessRate: p.rate(key + ".successRate", f.SuccessRate),
+		FastRate: p.rate(key + ".fastRate", f.FastRate),
+		WarningSuccessRate: p.rate(key + ".warningSuccessRate", f.WarningSuccessRate),
+		WarningFastRate: p.rate(key + ".warningFastRate", f.WarningFastRate),
+		AlertForNoDataReachedTimes: f.AlertForNoDataReachedTimes,
+		EntryRetentionCycles: f.EntryRetentionCycles,
+		TrafficDropRate: p.rate(key + ".trafficDropRate", f.TrafficDropRate),
+		TrafficBaselineCycles: f.TrafficBaselineCycles,
+		AlertForTrafficDropReachedTimes: f.AlertForTrafficDropReachedTimes,
+		MinRequestCount: f.MinRequestCount,
+		WilsonConfidence: f.WilsonConfidence,
+		ChannelCacheCount: f.ChannelCacheCount,
+		GetCodeFeature: l.CodeFeatures[f.CodeFeature],
+		DefaultFailDistributionFormat: f.DefaultFailDistributionFormat,
+		OutputCaller: l.Outputs[f.Output],
+		HistorySize: f.HistorySize,
+		ResolutionOutputCaller: l.Outputs[f.ResolutionOutput],
+		AlertCaller: l.Alerts[f.Alert],
+		RecoverCaller: l.Alerts[f.Recover],
+		RepeatInterval: p.duration(key + ".repeatInterval", f.RepeatInterval),
+		RepeatCaller: l.Alerts[f.Repeat],
+		EscalateAfter: p.duration(key + ".escalateAfter", f.EscalateAfter),
+		EscalationCaller: l.Alerts[f.Escalation],
+		FlappingCaller: l.Alerts[f.Flapping],
+		FlapThreshold: f.FlapThreshold,
+		FlapWindow: p.duration(key + ".flapWindow", f.FlapWindow),
+		EventCaller: l.Events[f.Event],
+		StateDir: f.StateDir,
+		StateMaxAge: p.duration(key + ".stateMaxAge", f.StateMaxAge),
+		Registry: l.Registry,
+	}
+	p.reference(key + ".codeFeature", "状态码判定方式", f.CodeFeature, c.GetCodeFeature != nil)
+	p.reference(key + ".output", "输出", f.Output, c.OutputCaller != nil)
+	p.reference(key + ".resolutionOutput", "输出", f.ResolutionOutput, c.ResolutionOutputCaller != nil)
+	p.reference(key + ".alert", "告警通知", f.Alert, c.AlertCaller != nil)
+	p.reference(key + ".recover", "告警通知", f.Recover, c.RecoverCaller != nil)
+	p.reference(key + ".repeat", "告警通知", f.Repeat, c.RepeatCaller != nil)
+	p.reference(key + ".escalation", "告警通知", f.Escalation, c.EscalationCaller != nil)
+	p.reference(key + ".flapping", "告警通知", f.Flapping, c.FlappingCaller != nil)
+	p.reference(key + ".event", "告警事件处理", f.Event, c.EventCaller != nil)
+	c.AlertManager = p.alertManagers[f.AlertManager]
+	if c.AlertManager == nil {
+		c.AlertManager = l.AlertManagers[f.AlertManager]
+	}
+	p.reference(key + ".alertManager", "告警管理器", f.AlertManager, c.AlertManager != nil)
+	if c.WilsonConfidence < 0 || c.WilsonConfidence >= 1 {
+		p.fail(key + ".wilsonConfidence", "必须介于0和1之间")
+	}
+	// 与注册时的默认值保持一致，用于校验降采样的粒度
+	cycle := time.Duration(c.StatisticalCycle) * time.Millisecond
+	if cycle <= 0 || cycle > 5 * time.Minute {
+		cycle = time.Minute
+	}
+	for i, s := range f.Resolutions {
+		resolutionKey := fmt.Sprintf("%s.resolutions[%d]", key, i)
+		resolution := p.duration(resolutionKey, s)
+		if resolution <= cycle {
+			p.fail(resolutionKey, "降采样的粒度必须大于统计周期")
+		}
+		c.Resolutions = append(c.Resolutions, resolution)
+	}
+	for i, raw := range f.Rollups {
+		rollupKey := fmt.Sprintf("%s.rollups[%d]", key, i)
+		rollup := Rollup {}
+		if !p.decode(raw, rollupKey, &rollup) {
+			continue
+		}
+		if rollup.Name == "" {
+			p.fail(rollupKey + ".name", "必须为汇总条目指定一个名称")
+		}
+		c.Rollups = append(c.Rollups, rollup)
+	}
+	codes := make([]int, 0, len(f.CodeFeatureMap))
+	for code := range f.CodeFeatureMap {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		feature := CodeFeature {}
+		if !p.decode(f.CodeFeatureMap[code], key + ".codeFeatureMap[" + strconv.Quote(strconv.Itoa(code)) + "]", &feature) {
+			continue
+		}
+		if c.CodeFeatureMap == nil {
+			c.CodeFeatureMap = map[int]CodeFeature {}
+		}
+		c.CodeFeatureMap[code] = feature
+	}
+	// 按名称排列，使出错时报告的配置项是确定的
+	severityNames := make([]string, 0, len(f.SeverityCallers))
+	for name := range f.SeverityCallers {
+		severityNames = append(severityNames, name)
+	}
+	sort.Strings(severityNames)
+	for _, name := range severityNames {
+		event := f.SeverityCallers[name]
+		severityKey := key + ".severityCallers." + name
+		severity := p.severity(severityKey, name)
+		if c.SeverityCallers == nil {
+			c.SeverityCallers = map[Severity]func(e *AlertEvent) {}
+		}
+		c.SeverityCallers[severity] = l.Events[event]
+		p.reference(severityKey, "告警事件处理", event, c.SeverityCallers[severity] != nil)
+	}
+	for i, raw := range f.MaintenanceWindows {
+		windowKey := fmt.Sprintf("%s.maintenanceWindows[%d]", key, i)
+		window := maintenanceWindowFileConfig {}
+		if p.decode(raw, windowKey, &window) {
+			c.MaintenanceWindows = append(c.MaintenanceWindows, p.maintenanceWindow(windowKey, &window))
+		}
+	}
+	return c
+}
+
+// 转换告警管理器
+func (p *configParser) alertManager(key string, f *alertManagerFileConfig) *AlertManager {
+	m := &AlertManager {
+		DefaultReceiver: f.DefaultReceiver,
+		Receivers: map[string]Receiver {},
+		GroupBy: f.GroupBy,
+		GroupWait: p.duration(key + ".groupWait", f.GroupWait),
+		GroupInterval: p.duration(key + ".groupInterval", f.GroupInterval),
+	}
+	p.receiver(key + ".defaultReceiver", f.DefaultReceiver, m)
+	m.Routes = p.routes(key + ".routes", f.Routes, m)
+	for i, raw := range f.InhibitRules {
+		ruleKey := fmt.Sprintf("%s.inhibitRules[%d]", key, i)
+		r := inhibitRuleFileConfig {}
+		if !p.decode(raw, ruleKey, &r) {
+			continue
+		}
+		m.InhibitRules = append(m.InhibitRules, InhibitRule {
+			Source: p.alertSelector(ruleKey + ".source", r.Source),
+			Target: p.alertSelector(ruleKey + ".target", r.Target),
+			Equal: r.Equal,
+		})
+	}
+	return m
+}
+
+// 转换路由规则及其子路由
+func (p *configParser) routes(key string, raws []json.RawMessage, m *AlertManager) []Route {
+	var routes []Route
+	for i, raw := range raws {
+		routeKey := fmt.Sprintf("%s[%d]", key, i)
+		f := routeFileConfig {}
+		if !p.decode(raw, routeKey, &f) {
+			continue
+		}
+		p.receiver(routeKey + ".receiver", f.Receiver, m)
+		routes = append(routes, Route {
+			ClientName: f.ClientName,
+			EntryPattern: f.EntryPattern,
+			AlertTypes: p.alertTypes(routeKey + ".alertTypes", f.AlertTypes),
+			Severities: p.severities(routeKey + ".severities", f.Severities),
+			Receiver: f.Receiver,
+			Continue: f.Continue,
+			Routes: p.routes(routeKey + ".routes", f.Routes, m),
+		})
+	}
+	return routes
+}
+
+// 转换告警的选择条件
+func (p *configParser) alertSelector(key string, raw json.RawMessage) AlertSelector {
+	f := alertSelectorFileConfig {}
+	if configPresent(raw) && !p.decode(raw, key, &f) {
+		return AlertSelector {}
+	}
+	return AlertSelector {
+		ClientName: f.ClientName,
+		EntryPattern: f.EntryPattern,
+		AlertTypes: p.alertTypes(key + ".alertTypes", f.AlertTypes),
+		Severities: p.severities(key + ".severities", f.Severities),
+	}
+}
+
+// 解析多个告警级别
+func (p *configParser) severities(key string, names []string) []Severity {
+	var severities []Severity
+	for i, name := range names {
+		severities = append(severities, p.severity(fmt.Sprintf("%s[%d]", key, i), name))
+	}
+	return severities
+}
+
+// 将引用的接收者加入告警管理器，接收者必须由加载器提供
+func (p *configParser) receiver(key string, name string, m *AlertManager) {
+	if name == "" {
+		return
+	}
+	receiver, ok := p.loader.Receivers[name]
+	if !ok {
+		p.fail(key, "未定义的接收者：" + name)
+		return
+	}
+	m.Receivers[name] = receiver
+}
+
+// 转换维护窗口
+func (p *configParser) maintenanceWindow(key string, f *maintenanceWindowFileConfig) MaintenanceWindow {
+	w := MaintenanceWindow {
+		EntryPattern: f.EntryPattern,
+		AlertTypes: p.alertTypes(key + ".alertTypes", f.AlertTypes),
+		Start: p.duration(key + ".start", f.Start),
+		Duration: p.duration(key + ".duration", f.Duration),
+		Comment: f.Comment,
+	}
+	for i, name := range f.Weekdays {
+		weekday := -1
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), name) {
+				weekday = int(d)
+			}
+		}
+		if weekday < 0 {
+			p.fail(fmt.Sprintf("%s.weekdays[%d]", key, i), "未知的星期：" + name)
+		}
+		w.Weekdays = append(w.Weekdays, time.Weekday(weekday))
+	}
+	if f.Location != "" {
+		location, err := time.LoadLocation(f.Location)
+		if err != nil {
+			p.fail(key + ".location", "未知的时区：" + f.Location)
+		}
+		w.Location = location
+	}
+	return w
+}
+
+// 转换条目配置
+func (p *configParser) entry(key string, f *entryFileConfig) EntryConfig {
+	e := f.EntryConfig
+	slo := sloFileConfig {}
+	if configPresent(f.SLO) && p.decode(f.SLO, key + ".slo", &slo) {
+		e.SLO = &SLOConfig {
+			Availability: slo.Availability,
+			Latency: slo.Latency,
+			Window: p.duration(key + ".slo.window", slo.Window),
+		}
+		for i, raw := range slo.BurnRateRules {
+			ruleKey := fmt.Sprintf("%s.slo.burnRateRules[%d]", key, i)
+			r := burnRateRuleFileConfig {}
+			if !p.decode(raw, ruleKey, &r) {
+				continue
+			}
+			e.SLO.BurnRateRules = append(e.SLO.BurnRateRules, BurnRateRule {
+				LongWindow: p.duration(ruleKey + ".longWindow", r.LongWindow),
+				ShortWindow: p.duration(ruleKey + ".shortWindow", r.ShortWindow),
+				BurnRate: r.BurnRate,
+				Severity: p.severity(ruleKey + ".severity", r.Severity),
+			})
+		}
+	}
+	anomaly := anomalyFileConfig {}
+	if configPresent(f.Anomaly) && p.decode(f.Anomaly, key + ".anomaly", &anomaly) {
+		e.Anomaly = &AnomalyConfig {
+			Deviation: anomaly.Deviation,
+			Alpha: anomaly.Alpha,
+			Season: p.duration(key + ".anomaly.season", anomaly.Season),
+			SeasonSlot: p.duration(key + ".anomaly.seasonSlot", anomaly.SeasonSlot),
+			WarmupCycles: anomaly.WarmupCycles,
+			AlertTimes: anomaly.AlertTimes,
+			RecoverTimes: anomaly.RecoverTimes,
+		}
+	}
+	// 与AddEntryConfig采用相同的校验，出错的配置项拼接在条目的路径之后
+	if _, err := normalizeEntryConfig(e); err != nil {
+		configError := err.(*ConfigError)
+		p.fail(joinConfigKey(key, configError.Key), configError.Message)
+	}
+	return e
+}
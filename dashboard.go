@@ -0,0 +1,240 @@
+package monitor
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 状态页的模板与静态资源
+//go:embed assets
+var assets embed.FS
+
+// 状态页模板
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap {
+	"percent": func(rate float64) string {
+		return strconv.FormatFloat(rate * 100, 'f', 2, 64) + "%"
+	},
+	"formatTime": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"alertTypeName": alertTypeName,
+	"severityClass": func(severity Severity) string {
+		switch severity {
+		case WARNING:
+			return "warning"
+		case CRITICAL:
+			return "critical"
+		}
+		return "normal"
+	},
+}).ParseFS(assets, "assets/*.html"))
+
+// 状态页中的一个客户端
+type dashboardClient struct {
+	Name string
+	Entries []dashboardEntry
+}
+
+// 状态页中的一个条目
+type dashboardEntry struct {
+	Name string
+	// 最近一个周期的统计数据
+	Latest *OutPutData
+	// 当前的告警
+	Alerts []ActiveAlert
+	// 成功率与平均耗时折线图的坐标
+	SuccessPoints string
+	LatencyPoints string
+}
+
+// 条目详情页
+type dashboardDetail struct {
+	dashboardEntry
+	Title string
+	Client string
+	Entry string
+	// 时延分布，按耗时区间排列
+	Latency []distributionBucket
+	// 失败分布，按次数从多到少排列
+	Failures []distributionBucket
+	// 最近的统计数据，最新的在前
+	History []OutPutData
+}
+
+// 分布中的一项
+type distributionBucket struct {
+	Name string
+	Count uint32
+	// 相对最大一项的宽度百分比
+	Width float64
+}
+
+// 状态页，列出一个注册表中的客户端
+type dashboard struct {
+	registry *Registry
+}
+
+// 内置的状态页，列出默认注册表中全部客户端的条目、最近统计数据、告警状态与近期趋势，点击条目可以查看时延与失败分布
+// 页面中的链接均为相对路径，挂载到子路径时需配合http.StripPrefix使用，例如
+// http.Handle("/monitor/", http.StripPrefix("/monitor", monitor.DashboardHandler()))
+func DashboardHandler() http.Handler {
+	return DefaultRegistry.DashboardHandler()
+}
+
+// 列出本注册表中客户端的状态页，用法同DashboardHandler
+func (r *Registry) DashboardHandler() http.Handler {
+	d := &dashboard {registry: r}
+	mux := http.NewServeMux()
+	static, _ := fs.Sub(assets, "assets")
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/entry", d.entryPage)
+	mux.HandleFunc("/", d.indexPage)
+	return mux
+}
+
+// 首页，列出全部客户端与条目
+func (d *dashboard) indexPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	clients := []dashboardClient {}
+	for _, c := range d.registry.registered() {
+		client := dashboardClient {Name: c.Name}
+		alerts := c.Alerts()
+		for _, name := range c.Entries() {
+			client.Entries = append(client.Entries, c.dashboardEntry(name, alerts))
+		}
+		clients = append(clients, client)
+	}
+	renderDashboard(w, "index", map[string]interface {} {
+		"Title": "状态",
+		"Clients": clients,
+	})
+}
+
+// 条目详情页，通过client和entry参数指定条目
+func (d *dashboard) entryPage(w http.ResponseWriter, r *http.Request) {
+	clientName, entryName := r.URL.Query().Get("client"), r.URL.Query().Get("entry")
+	client := d.registry.lookup(clientName)
+	if client == nil {
+		http.NotFound(w, r)
+		return
+	}
+	detail := dashboardDetail {
+		dashboardEntry: client.dashboardEntry(entryName, client.Alerts()),
+		Title: entryName,
+		Client: clientName,
+		Entry: entryName,
+	}
+	if detail.Latest == nil {
+		http.NotFound(w, r)
+		return
+	}
+	history := client.History(entryName, time.Time {}, time.Time {})
+	for i := len(history) - 1; i >= 0; i-- {
+		detail.History = append(detail.History, history[i])
+	}
+	detail.Latency = sortedDistribution(detail.Latest.TimeConsumingDistribution, func(a, b string) bool {
+		return distributionStartOf(a) < distributionStartOf(b)
+	})
+	detail.Failures = sortedDistribution(detail.Latest.FailDistribution, nil)
+	renderDashboard(w, "entry", detail)
+}
+
+// 输出页面
+func renderDashboard(w http.ResponseWriter, name string, data interface {}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// 条目的最近数据、告警与趋势
+func (c *ReportClientConfig) dashboardEntry(name string, alerts []ActiveAlert) dashboardEntry {
+	entry := dashboardEntry {Name: name}
+	if latest, ok := c.Latest(name); ok {
+		entry.Latest = &latest
+	}
+	for _, alert := range alerts {
+		if alert.InterfaceName == name {
+			entry.Alerts = append(entry.Alerts, alert)
+		}
+	}
+	history := c.History(name, time.Time {}, time.Time {})
+	successRates := make([]float64, len(history))
+	latencies := make([]float64, len(history))
+	var maxLatency float64
+	for i, o := range history {
+		successRates[i] = o.SuccessRate
+		latencies[i] = float64(o.SuccessMsAver)
+		if latencies[i] > maxLatency {
+			maxLatency = latencies[i]
+		}
+	}
+	entry.SuccessPoints = sparkline(successRates, 1)
+	entry.LatencyPoints = sparkline(latencies, maxLatency)
+	return entry
+}
+
+// 将一组数值转换为120*30的折线图坐标，纵轴按max缩放
+func sparkline(values []float64, max float64) string {
+	if max <= 0 {
+		max = 1
+	}
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := 120.0
+		if len(values) > 1 {
+			x = float64(i) * 120 / float64(len(values) - 1)
+		}
+		points[i] = strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(30 - v / max * 30, 'f', 1, 64)
+	}
+	return strings.Join(points, " ")
+}
+
+// 将分布排列为列表，less为空时按次数从多到少排列
+func sortedDistribution(distribution map[string]uint32, less func(a, b string) bool) []distributionBucket {
+	buckets := make([]distributionBucket, 0, len(distribution))
+	var max uint32
+	for name, count := range distribution {
+		buckets = append(buckets, distributionBucket {Name: name, Count: count})
+		if count > max {
+			max = count
+		}
+	}
+	for i := range buckets {
+		if max > 0 {
+			buckets[i].Width = float64(buckets[i].Count) * 100 / float64(max)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if less != nil {
+			return less(buckets[i].Name, buckets[j].Name)
+		}
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets
+}
+
+// 时延分布区间名称的起点，例如"<100"为0，"100~150"为100，">500"排在最后
+func distributionStartOf(name string) float64 {
+	if strings.HasPrefix(name, "<") {
+		return -1
+	}
+	if strings.HasPrefix(name, ">") {
+		v, _ := strconv.ParseFloat(name[1:], 64)
+		return v + 0.5
+	}
+	v, _ := strconv.ParseFloat(strings.SplitN(name, "~", 2)[0], 64)
+	return v
+}
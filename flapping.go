@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"time"
+)
+
+// 记录一次告警或恢复的状态变化，FlapWindow内的状态变化次数达到FlapThreshold时判定为抖动
+// 开始抖动时只发出一次抖动通知，之后的告警与恢复不再逐一通知，直到状态稳定下来
+func (c *ReportClientConfig) trackFlapping(status *alertStatus, alertType AlertType, entryName string, outputData OutPutData) {
+	if c.FlapThreshold <= 0 {
+		return
+	}
+	now := outputData.Timestamp
+	status.transitions = append(status.transitions, now)
+	status.pruneTransitions(now.Add(-c.FlapWindow))
+	if status.flapping || len(status.transitions) < c.FlapThreshold {
+		return
+	}
+	status.flapping = true
+	c.recordAlert(status, alertType, entryName, FLAPPING, outputData)
+	// 抖动通知视同一次告警通知，稳定之后据此补发告警或恢复通知
+	if !c.silenced(entryName, alertType, now) {
+		status.notified = true
+		status.notifiedAt = now
+		c.dispatch(c.newAlertEvent(status, alertType, entryName, FLAPPING, []OutPutData {outputData}, now))
+	}
+}
+
+// 检查抖动中的条目是否已经稳定，FlapWindow内的状态变化次数不超过FlapThreshold的一半即视为稳定
+// 稳定时仍处于告警中的，将由告警分析补发告警通知；已经恢复的，补发恢复通知
+func (c *ReportClientConfig) checkFlapStable(status *alertStatus, alertType AlertType, entryName string, outputData OutPutData) {
+	now := outputData.Timestamp
+	status.pruneTransitions(now.Add(-c.FlapWindow))
+	if len(status.transitions) > c.FlapThreshold / 2 {
+		return
+	}
+	status.flapping = false
+	if status.curState == alertType {
+		status.notified = false
+		return
+	}
+	c.notifyResolved(status, alertType, entryName, []OutPutData {outputData}, now)
+}
+
+// 淘汰指定时间（包含）之前的状态变化
+func (s *alertStatus) pruneTransitions(before time.Time) {
+	i := 0
+	for i < len(s.transitions) && !s.transitions[i].After(before) {
+		i++
+	}
+	s.transitions = s.transitions[i:]
+}
@@ -1,6 +1,16 @@
 package monitor
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -76,4 +86,1787 @@ func BenchmarkReport(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		testReportClient2.Report("GET - 性能测试", uint32(i), 200)
 	}
-}
\ No newline at end of file
+}
+
+// 在独立的注册表中注册一个统计周期足够长的客户端，以便直接调用内部分析方法进行测试，测试结束时关闭
+func registerTestClient(t *testing.T, c ReportClientConfig) *ReportClientConfig {
+	c.StatisticalCycle = 300000
+	c.Registry = NewRegistry()
+	client := Register(c).(*ReportClientConfig)
+	t.Cleanup(client.Close)
+	return client
+}
+
+// 执行一次告警分析，并等待产生的告警事件送达
+func analyze(c *ReportClientConfig, name string, outputData OutPutData) {
+	c.alertAnalyze(name, outputData)
+	c.eventQueue.pending.Wait()
+}
+
+// 构造一个周期的收集数据
+func testReportData(name string, config *EntryConfig, successCount uint32, fastCount uint32, failCount uint32, t time.Time) *reportData {
+	return &reportData {
+		Name: name,
+		SuccessCount: successCount,
+		FastCount: fastCount,
+		FailCount: failCount,
+		Config: config,
+		Time: t,
+	}
+}
+
+func TestSLOBurnRateAlert(t *testing.T) {
+	var alerts, recovers []AlertType
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "SLO测试",
+		SuccessRate: 0.01,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			alerts = append(alerts, alertType)
+			if recentOutputData[0].ErrorBudget == nil {
+				t.Error("告警数据缺少错误预算信息")
+			}
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			recovers = append(recovers, alertType)
+		},
+	})
+	c.AddEntryConfig("GET - 测试接口", EntryConfig {
+		SLO: &SLOConfig {
+			Availability: 0.99,
+			BurnRateRules: []BurnRateRule {{LongWindow: 10 * time.Minute, ShortWindow: time.Minute, BurnRate: 10}},
+		},
+	})
+	config := c.getEntryConfig("GET - 测试接口")
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 前5分钟全部成功，之后2分钟失败率80%，最后恢复
+	failures := []uint32 {0, 0, 0, 0, 0, 80, 80, 0, 0}
+	var last OutPutData
+	for i, fail := range failures {
+		now := start.Add(time.Duration(i) * time.Minute)
+		collected := testReportData("GET - 测试接口", config, 100 - fail, 100 - fail, fail, now)
+		last = OutPutData {InterfaceName: collected.Name, Count: 100, SuccessCount: 100 - fail, FastCount: 100 - fail, SuccessRate: float64(100 - fail) / 100, FastRate: float64(100 - fail) / 100, Timestamp: now}
+		last.ErrorBudget = c.sloAnalyze(collected, now)
+		analyze(c, collected.Name, last)
+		if i == 5 && len(alerts) != 1 {
+			t.Error("燃烧率达到阈值时应当立即告警", alerts)
+		}
+	}
+	if len(alerts) != 1 || alerts[0] != FAIL_BUDGET || len(recovers) != 1 || recovers[0] != FAIL_BUDGET {
+		t.Error("燃烧率告警与恢复次数不符", alerts, recovers)
+	}
+	// 900次调用失败160次，而预算只允许失败9次
+	remaining := last.ErrorBudget.Availability.Remaining
+	if remaining > -16.7 || remaining < -16.8 {
+		t.Error("剩余错误预算计算错误", remaining)
+	}
+}
+
+// 构造一个周期的输出数据
+func testOutputData(name string, count uint32, successCount uint32, fastCount uint32, t time.Time) OutPutData {
+	return OutPutData {
+		InterfaceName: name,
+		Count: count,
+		SuccessCount: successCount,
+		FailCount: count - successCount,
+		SuccessRate: float64(successCount) / float64(count),
+		FastCount: fastCount,
+		FastRate: float64(fastCount) / float64(count),
+		Timestamp: t,
+	}
+}
+
+func TestInsufficientData(t *testing.T) {
+	alertTimes := 0
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "最少调用次数测试",
+		MinRequestCount: 10,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			alertTimes++
+		},
+	})
+	now := time.Now()
+	// 数据不足的周期夹在不达标周期之间，既不计入也不打断
+	for i, count := range []uint32 {20, 20, 2, 2, 20} {
+		o := testOutputData("GET - 测试接口", count, count / 2, count / 2, now)
+		o.InsufficientData = int(count) < c.MinRequestCount
+		analyze(c, o.InterfaceName, o)
+		if i < 4 && alertTimes != 0 {
+			t.Error("数据不足的周期不应当推进告警", i)
+		}
+	}
+	if alertTimes != 2 {
+		t.Error("成功率与时延达标率均应告警", alertTimes)
+	}
+}
+
+func TestWilsonConfidence(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "置信区间测试",
+		WilsonConfidence: 0.95,
+	})
+	if c.belowRate(1, 2, 0.8) {
+		t.Error("2次调用失败1次不足以判定成功率不达标")
+	}
+	if !c.belowRate(500, 1000, 0.8) {
+		t.Error("1000次调用失败500次应当判定成功率不达标")
+	}
+}
+
+func TestNoDataAndTrafficDrop(t *testing.T) {
+	var events []string
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "调用量测试",
+		AlertForNoDataReachedTimes: 2,
+		TrafficDropRate: 0.5,
+		TrafficBaselineCycles: 3,
+		AlertForTrafficDropReachedTimes: 2,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "alert:" + alertTypeName(alertType))
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "recover:" + alertTypeName(alertType))
+		},
+	})
+	now := time.Now()
+	for _, count := range []uint32 {100, 100, 100, 40, 40, 100, 100, 0, 0, 100} {
+		collected := testReportData("GET - 测试接口", defaultEntryConfig, count, count, 0, now)
+		o := testOutputData(collected.Name, count, count, count, now)
+		o.TrafficBaseline = c.trafficAnalyze(collected)
+		analyze(c, o.InterfaceName, o)
+	}
+	expected := []string {"alert:调用量", "recover:调用量", "alert:无数据", "recover:无数据"}
+	if len(events) != len(expected) {
+		t.Fatal("告警与恢复事件不符", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Error("告警与恢复事件不符", events)
+		}
+	}
+}
+
+func TestAnomalyAlert(t *testing.T) {
+	var events []string
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "异常检测测试",
+		SuccessRate: 0.01,
+		FastRate: 0.01,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "alert:" + alertTypeName(alertType))
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "recover:" + alertTypeName(alertType))
+		},
+	})
+	c.AddEntryConfig("GET - 测试接口", EntryConfig {
+		Anomaly: &AnomalyConfig {WarmupCycles: 5, AlertTimes: 2, RecoverTimes: 2},
+	})
+	config := c.getEntryConfig("GET - 测试接口")
+	detector := &anomalyDetector {}
+	now := time.Now()
+	// 成功率长期在92%左右，本身低于常规阈值但属于正常，跌至50%时才是异常
+	for i, success := range []uint32 {92, 91, 93, 92, 90, 92, 93, 91, 50, 50, 92, 92} {
+		o := testOutputData("GET - 测试接口", 100, success, success, now.Add(time.Duration(i) * time.Minute))
+		o.config = config
+		o.Anomaly = detector.detect(&o, config.Anomaly)
+		if (o.Anomaly == nil) != (i < 5) {
+			t.Error("只有预热完成之后才输出异常检测结果", i, o.Anomaly)
+		}
+		analyze(c, o.InterfaceName, o)
+	}
+	if len(events) != 2 || events[0] != "alert:异常检测" || events[1] != "recover:异常检测" {
+		t.Error("异常告警与恢复事件不符", events)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	var events []string
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "静默测试",
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "alert:" + interfaceName)
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			events = append(events, "recover:" + interfaceName)
+		},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.Silence(Silence {EntryPattern: "GET - /api/*", AlertTypes: []AlertType {FAIL}, StartsAt: start, EndsAt: start.Add(3 * time.Minute), CreatedBy: "tester"})
+	id := c.Silence(Silence {EntryPattern: "POST - *", EndsAt: start.Add(time.Hour)})
+	if len(c.silenceStore.silences) != 2 {
+		t.Fatal("静默规则添加失败")
+	}
+	// 静默期间触发的告警在静默结束后补发，一直处于静默中的告警不发出告警和恢复通知
+	for i, success := range []uint32 {0, 0, 0, 0, 100, 100, 100} {
+		now := start.Add(time.Duration(i) * time.Minute)
+		analyze(c, "GET - /api/users", testOutputData("GET - /api/users", 100, success, success, now))
+		analyze(c, "POST - /api/users", testOutputData("POST - /api/users", 100, success, success, now))
+	}
+	if len(events) != 2 || events[0] != "alert:GET - /api/users" || events[1] != "recover:GET - /api/users" {
+		t.Error("静默期间的告警与恢复事件不符", events)
+	}
+	// 告警发出之后进入静默，静默期间恢复的通知在静默结束后补发
+	events = nil
+	start = start.Add(time.Hour)
+	c.Silence(Silence {EntryPattern: "DELETE - *", StartsAt: start.Add(3 * time.Minute), EndsAt: start.Add(8 * time.Minute)})
+	for i, success := range []uint32 {0, 0, 0, 0, 100, 100, 100, 100, 100} {
+		now := start.Add(time.Duration(i) * time.Minute)
+		analyze(c, "DELETE - /api/users", testOutputData("DELETE - /api/users", 100, success, success, now))
+		if i == 7 && len(events) != 1 {
+			t.Error("静默期间不应发出恢复通知", events)
+		}
+	}
+	if len(events) != 2 || events[0] != "alert:DELETE - /api/users" || events[1] != "recover:DELETE - /api/users" {
+		t.Error("静默结束后应当补发恢复通知", events)
+	}
+	if !c.Unsilence(id) || c.Unsilence(id) {
+		t.Error("删除静默规则失败")
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	w := MaintenanceWindow {Weekdays: []time.Weekday {time.Monday}, Start: 23 * time.Hour, Duration: 2 * time.Hour, Location: time.UTC}
+	// 2018-01-01是周一
+	monday := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	if w.active(monday.Add(22 * time.Hour)) || !w.active(monday.Add(23 * time.Hour)) || !w.active(monday.Add(24 * time.Hour + 30 * time.Minute)) || w.active(monday.Add(25 * time.Hour)) {
+		t.Error("维护窗口的生效时间不符")
+	}
+	if !matchPattern("GET - /api/*/detail", "GET - /api/users/1/detail") || matchPattern("GET - /api/?", "GET - /api/users") {
+		t.Error("通配符匹配不符")
+	}
+}
+
+func TestRepeatAndEscalation(t *testing.T) {
+	var events []*AlertEvent
+	alertTimes, repeatTimes, escalationTimes := 0, 0, 0
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "重复通知测试",
+		RepeatInterval: 2 * time.Minute,
+		EscalateAfter: 5 * time.Minute,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			alertTimes++
+		},
+		RepeatCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			repeatTimes++
+		},
+		EscalationCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			escalationTimes++
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		EventCaller: func(e *AlertEvent) {
+			events = append(events, e)
+		},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 第2分钟告警，第4分钟重复，第5分钟升级，第7分钟重复（同时发给升级对象），之后恢复
+	for i := 0; i < 11; i++ {
+		success := uint32(0)
+		if i >= 8 {
+			success = 100
+		}
+		analyze(c, "GET - 测试接口", testOutputData("GET - 测试接口", 100, success, success, start.Add(time.Duration(i) * time.Minute)))
+	}
+	expected := []EventType {FIRING, REPEAT, ESCALATE, REPEAT, RESOLVED}
+	if len(events) != len(expected) {
+		t.Fatal("告警事件个数不符", len(events))
+	}
+	for i, e := range events {
+		if e.EventType != expected[i] {
+			t.Error("告警事件类型不符", i, e.EventType)
+		}
+	}
+	if events[3].Duration != 7 * time.Minute || !events[3].Escalated {
+		t.Error("重复通知的持续时间不符", events[3].Duration)
+	}
+	// 重复通知交给RepeatCaller，已升级之后的重复通知同时交给升级对象
+	if alertTimes == 0 || repeatTimes != 2 || escalationTimes != 2 {
+		t.Error("告警、重复与升级通知次数不符", alertTimes, repeatTimes, escalationTimes)
+	}
+}
+
+func TestSeverityTiers(t *testing.T) {
+	var events []*AlertEvent
+	warnings, criticals, alertTimes, recoverTimes := 0, 0, 0, 0
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "告警级别测试",
+		SuccessRate: 0.9,
+		WarningSuccessRate: 0.99,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			alertTimes++
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			recoverTimes++
+		},
+		EventCaller: func(e *AlertEvent) {
+			events = append(events, e)
+		},
+		SeverityCallers: map[Severity]func(e *AlertEvent) {
+			WARNING: func(e *AlertEvent) { warnings++ },
+			CRITICAL: func(e *AlertEvent) { criticals++ },
+		},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, success := range []uint32 {95, 95, 95, 80, 95, 100, 100, 100} {
+		analyze(c, "GET - 测试接口", testOutputData("GET - 测试接口", 100, success, 100, start.Add(time.Duration(i) * time.Minute)))
+	}
+	expected := []struct {
+		eventType EventType
+		severity Severity
+		prevSeverity Severity
+	} {
+		{FIRING, WARNING, NORMAL},
+		{SEVERITY_CHANGED, CRITICAL, WARNING},
+		{SEVERITY_CHANGED, WARNING, CRITICAL},
+		{RESOLVED, NORMAL, WARNING},
+	}
+	if len(events) != len(expected) {
+		t.Fatal("告警事件个数不符", len(events))
+	}
+	for i, e := range events {
+		if e.EventType != expected[i].eventType || e.Severity != expected[i].severity || e.PrevSeverity != expected[i].prevSeverity {
+			t.Error("告警事件不符", i, e.EventType, e.Severity, e.PrevSeverity)
+		}
+	}
+	// 恢复事件不属于任何告警级别，不再按警告级别分发
+	if warnings != 2 || criticals != 1 {
+		t.Error("按级别分发的次数不符", warnings, criticals)
+	}
+	// 级别的变化只交给EventCaller与SeverityCallers，旧式回调只在告警与恢复时各调用一次
+	if alertTimes != 1 || recoverTimes != 1 {
+		t.Error("旧式回调的调用次数不符", alertTimes, recoverTimes)
+	}
+}
+
+func TestAlertManagerRoute(t *testing.T) {
+	received := map[string]int {}
+	receiver := func(n *Notification) {
+		received[n.Receiver] += len(n.Events)
+	}
+	m := &AlertManager {
+		DefaultReceiver: "default",
+		Receivers: map[string]Receiver {"default": receiver, "db": receiver, "web": receiver, "pager": receiver, "slow": receiver},
+		Routes: []Route {
+			{ClientName: "db*", Receiver: "db"},
+			{EntryPattern: "GET - /api/*", Receiver: "web", Continue: true, Routes: []Route {
+				{Severities: []Severity {CRITICAL}, Receiver: "pager"},
+			}},
+			{AlertTypes: []AlertType {SLOW}, Receiver: "slow"},
+		},
+	}
+	cases := []struct {
+		event AlertEvent
+		receivers string
+	} {
+		{AlertEvent {ClientName: "db监控", InterfaceName: "GET - /api/users", AlertType: SLOW, Severity: CRITICAL}, "db"},
+		{AlertEvent {ClientName: "http", InterfaceName: "GET - /api/users", AlertType: FAIL, Severity: WARNING}, "web"},
+		{AlertEvent {ClientName: "http", InterfaceName: "GET - /api/users", AlertType: SLOW, Severity: CRITICAL}, "pager,slow"},
+		{AlertEvent {ClientName: "http", InterfaceName: "POST - /login", AlertType: FAIL, Severity: CRITICAL}, "default"},
+	}
+	for _, cs := range cases {
+		receivers := ""
+		for i, name := range m.route(&cs.event) {
+			if i > 0 {
+				receivers += ","
+			}
+			receivers += name
+		}
+		if receivers != cs.receivers {
+			t.Error("路由结果不符", cs.event.ClientName, cs.event.InterfaceName, receivers)
+		}
+	}
+	m.dispatch(&cases[2].event)
+	if received["pager"] != 1 || received["slow"] != 1 {
+		t.Error("通知未送达接收者", received)
+	}
+}
+
+func TestAlertManagerGroup(t *testing.T) {
+	notifications := make(chan *Notification, 10)
+	m := &AlertManager {
+		DefaultReceiver: "default",
+		Receivers: map[string]Receiver {"default": func(n *Notification) { notifications <- n }},
+		GroupBy: []string {"team"},
+		GroupWait: 50 * time.Millisecond,
+		GroupInterval: 200 * time.Millisecond,
+	}
+	labels := map[string]string {"team": "db"}
+	for _, name := range []string {"GET - /a", "GET - /b", "GET - /a"} {
+		m.dispatch(&AlertEvent {ClientName: "http", InterfaceName: name, Labels: labels, EventType: FIRING, AlertType: FAIL})
+	}
+	first := <-notifications
+	if len(first.Events) != 2 || first.GroupLabels["team"] != "db" {
+		t.Fatal("分组通知应当合并且去重", len(first.Events), first.GroupKey)
+	}
+	sent := time.Now()
+	m.dispatch(&AlertEvent {ClientName: "http", InterfaceName: "GET - /c", Labels: labels, EventType: FIRING, AlertType: FAIL})
+	second := <-notifications
+	if len(second.Events) != 1 || time.Since(sent) < 150 * time.Millisecond {
+		t.Error("同一分组的通知间隔不符", time.Since(sent))
+	}
+}
+
+func TestAlertManagerInhibit(t *testing.T) {
+	var received []*AlertEvent
+	m := &AlertManager {
+		DefaultReceiver: "default",
+		Receivers: map[string]Receiver {"default": func(n *Notification) { received = append(received, n.Events...) }},
+		InhibitRules: []InhibitRule {
+			{Source: AlertSelector {ClientName: "db", AlertTypes: []AlertType {FAIL}}, Target: AlertSelector {ClientName: "http*"}},
+		},
+	}
+	m.dispatch(&AlertEvent {ClientName: "db", InterfaceName: "SELECT users", AlertType: FAIL, EventType: FIRING})
+	m.dispatch(&AlertEvent {ClientName: "http服务", InterfaceName: "GET - /api/users", AlertType: FAIL, EventType: FIRING})
+	if len(received) != 1 || len(m.ActiveAlerts()) != 2 {
+		t.Fatal("依赖数据库的告警应当被抑制", len(received))
+	}
+	inhibited := m.InhibitedAlerts()
+	if len(inhibited) != 1 || inhibited[0].InhibitedBy.ClientName != "db" {
+		t.Fatal("被抑制的告警不符", inhibited)
+	}
+	// 源告警恢复之后，仍在告警中的目标告警补发通知
+	m.dispatch(&AlertEvent {ClientName: "db", InterfaceName: "SELECT users", AlertType: FAIL, EventType: RESOLVED})
+	if len(received) != 3 || received[2].ClientName != "http服务" || received[2].EventType != FIRING || len(m.InhibitedAlerts()) != 0 {
+		t.Error("源告警恢复后应当补发目标告警", len(received))
+	}
+}
+
+func TestStatePersistence(t *testing.T) {
+	store := NewFileStateStore(t.TempDir())
+	recoverTimes := 0
+	config := ReportClientConfig {
+		Name: "持久化测试",
+		StateStore: store,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			recoverTimes++
+		},
+	}
+	c := registerTestClient(t, config)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		analyze(c, "GET - 测试接口", testOutputData("GET - 测试接口", 100, 0, 0, now))
+	}
+	c.saveState(now)
+	c.Close()
+
+	// 重启之后恢复告警状态，恢复通知照常发出
+	restarted := registerTestClient(t, config)
+	if restarted.getAlertStatus(FAIL, "GET - 测试接口").curState != FAIL {
+		t.Fatal("告警状态未恢复")
+	}
+	for i := 0; i < 3; i++ {
+		analyze(restarted, "GET - 测试接口", testOutputData("GET - 测试接口", 100, 100, 100, now))
+	}
+	if recoverTimes != 1 {
+		t.Error("重启之后应当发出恢复通知", recoverTimes)
+	}
+
+	restarted.Close()
+
+	// 过期的状态将被丢弃
+	c.saveState(now.Add(-2 * time.Hour))
+	expired := registerTestClient(t, config)
+	if expired.getAlertStatus(FAIL, "GET - 测试接口").curState != NONE {
+		t.Error("过期的告警状态应当被丢弃")
+	}
+}
+
+func TestAlertHistory(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "告警历史测试",
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 第2分钟告警第5分钟恢复，第8分钟告警第12分钟恢复
+	for i := 0; i < 13; i++ {
+		success := uint32(100)
+		if i < 3 || (i >= 6 && i < 10) {
+			success = 0
+		}
+		analyze(c, "GET - 测试接口", testOutputData("GET - 测试接口", 100, success, success, start.Add(time.Duration(i) * time.Minute)))
+	}
+	records := c.AlertRecords(AlertHistoryQuery {EntryPattern: "GET - *", AlertTypes: []AlertType {FAIL}})
+	expected := []EventType {FIRING, RESOLVED, FIRING, RESOLVED}
+	if len(records) != len(expected) {
+		t.Fatal("告警历史记录个数不符", len(records))
+	}
+	for i, r := range records {
+		if r.EventType != expected[i] {
+			t.Error("告警历史记录类型不符", i, r.EventType)
+		}
+	}
+	if records[3].Duration != 6 * time.Minute || records[3].Summary.SuccessRate != 1 || !records[3].Notified {
+		t.Error("恢复记录不符", records[3])
+	}
+	if n := len(c.AlertRecords(AlertHistoryQuery {AlertTypes: []AlertType {FAIL}, From: start.Add(6 * time.Minute)})); n != 2 {
+		t.Error("按时间范围查询的记录个数不符", n)
+	}
+	stats := c.AlertStats(AlertHistoryQuery {AlertTypes: []AlertType {FAIL}})
+	if len(stats) != 1 || stats[0].AlertCount != 2 || stats[0].ResolvedCount != 2 || stats[0].MTTR != 330 * time.Second || stats[0].LongestDuration != 6 * time.Minute {
+		t.Error("告警统计不符", stats)
+	}
+	// 超过容量时淘汰最早的记录
+	h := NewAlertHistory(2)
+	for i := 0; i < 3; i++ {
+		h.Record(AlertRecord {ClientName: "c", Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	if records := h.Query(AlertHistoryQuery {}); len(records) != 2 || !records[0].Time.Equal(start.Add(time.Minute)) {
+		t.Error("告警历史容量限制不符", records)
+	}
+}
+
+func TestAlertWindow(t *testing.T) {
+	var events []*AlertEvent
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "滑动窗口测试",
+		AlertWindow: 5,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		EventCaller: func(e *AlertEvent) {
+			if e.AlertType == FAIL {
+				events = append(events, e)
+			}
+		},
+	})
+	c.AddEntryConfig("GET - 连续模式", EntryConfig {AlertWindow: 1})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 不达标与达标交替出现，连续模式下永远不会告警，滑动窗口模式下第4个周期即告警，之后5个周期中有3个达标时恢复
+	pipeline := []bool {false, true, false, false, true, false, true, true}
+	for _, name := range []string {"GET - 滑动窗口", "GET - 连续模式"} {
+		config := c.getEntryConfig(name)
+		for i, success := range pipeline {
+			successCount := uint32(0)
+			if success {
+				successCount = 100
+			}
+			outputData := testOutputData(name, 100, successCount, successCount, start.Add(time.Duration(i) * time.Minute))
+			outputData.config = config
+			analyze(c, name, outputData)
+		}
+	}
+	expected := []EventType {FIRING, RESOLVED}
+	if len(events) != len(expected) {
+		t.Fatal("告警事件个数不符", len(events))
+	}
+	for i, e := range events {
+		if e.InterfaceName != "GET - 滑动窗口" || e.EventType != expected[i] {
+			t.Error("告警事件不符", i, e.InterfaceName, e.EventType)
+		}
+	}
+	if !events[0].StartsAt.Equal(start) || len(events[0].RecentOutputData) != 4 {
+		t.Error("告警开始时间或数据不符", events[0].StartsAt, len(events[0].RecentOutputData))
+	}
+	if !events[1].Time.Equal(start.Add(7 * time.Minute)) {
+		t.Error("恢复时间不符", events[1].Time)
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	var events []*AlertEvent
+	flappingTimes := 0
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "抖动测试",
+		AlertForNoDataReachedTimes: 1,
+		FlapThreshold: 4,
+		FlapWindow: 10 * time.Minute,
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+		FlappingCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			flappingTimes++
+		},
+		EventCaller: func(e *AlertEvent) {
+			events = append(events, e)
+		},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 前8分钟有无调用交替出现，第3分钟开始抖动，第15分钟窗口内只剩2次状态变化，视为稳定并补发恢复通知
+	for i := 0; i < 20; i++ {
+		count := uint32(100)
+		if i < 8 && i % 2 == 0 {
+			count = 0
+		}
+		analyze(c, "GET - 测试接口", testOutputData("GET - 测试接口", count, count, count, start.Add(time.Duration(i) * time.Minute)))
+	}
+	expected := []EventType {FIRING, RESOLVED, FIRING, FLAPPING, RESOLVED}
+	if len(events) != len(expected) {
+		t.Fatal("告警事件个数不符", len(events))
+	}
+	for i, e := range events {
+		if e.EventType != expected[i] {
+			t.Error("告警事件类型不符", i, e.EventType)
+		}
+	}
+	if flappingTimes != 1 || !events[4].Time.Equal(start.Add(15 * time.Minute)) {
+		t.Error("抖动通知次数或稳定时间不符", flappingTimes, events[4].Time)
+	}
+}
+
+func TestEntryAlertRule(t *testing.T) {
+	alerts := map[string]int {}
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "条目告警阈值测试",
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			if alertType == FAIL {
+				alerts[interfaceName]++
+			}
+		},
+		RecoverCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+	})
+	c.AddEntryConfig("POST - /login", EntryConfig {SuccessRate: 0.999, AlertForBadSuccessRateReachedTimes: 1})
+	c.AddEntryConfig("GET - /export", EntryConfig {SuccessRate: 0.9})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 登录接口99%的成功率一个周期即告警，导出接口92%与其他接口99%的成功率沿用客户端的连续3个周期也不告警
+	for _, name := range []string {"POST - /login", "GET - /export", "GET - /users"} {
+		config := c.getEntryConfig(name)
+		success := uint32(99)
+		if name == "GET - /export" {
+			success = 92
+		}
+		for i := 0; i < 3; i++ {
+			outputData := testOutputData(name, 100, success, success, start.Add(time.Duration(i) * time.Minute))
+			outputData.config = config
+			analyze(c, name, outputData)
+		}
+	}
+	if len(alerts) != 1 || alerts["POST - /login"] != 1 {
+		t.Error("条目告警阈值不符", alerts)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "汇总条目测试",
+		Rollups: []Rollup {
+			{Name: "全部接口"},
+			{Name: "v2接口", EntryPatterns: []string {"* - /api/v2/*"}},
+			{Name: "v3接口", EntryPatterns: []string {"* - /api/v3/*"}},
+		},
+	})
+	c.AddEntryConfig("GET - /api/v2/users", EntryConfig {FastLessThan: 50, TimeConsumingDistributionMin: 10, TimeConsumingDistributionMax: 100})
+	reports := []reportServer {
+		{Name: "GET - /api/v1/users", Ms: 300, Code: 200},
+		{Name: "GET - /api/v2/users", Ms: 20, Code: 200},
+		{Name: "GET - /api/v2/users", Ms: 0, Code: 500},
+		{Name: "POST - /api/v2/orders", Ms: 600, Code: 200},
+	}
+	for i := range reports {
+		c.serverTask(&reports[i])
+	}
+	rollupDataList := c.rollupData(time.Now())
+	if len(rollupDataList) != 2 {
+		t.Fatal("汇总条目个数不符", len(rollupDataList))
+	}
+	all, v2 := rollupDataList[0], rollupDataList[1]
+	if all.Name != "全部接口" || all.SuccessCount != 3 || all.FailCount != 1 || all.MinMs != 20 || all.MaxMs != 600 {
+		t.Error("全部接口汇总数据不符", all)
+	}
+	if v2.Name != "v2接口" || v2.SuccessCount != 2 || v2.FailCount != 1 || v2.FastCount != 1 || v2.FailDistribution[500] != 1 {
+		t.Error("v2接口汇总数据不符", v2)
+	}
+	// 20ms按默认配置归入第一个区间，600ms归入最后一个区间
+	if v2.TimeConsumingDistribution[0] != 1 || v2.TimeConsumingDistribution[v2.Config.TimeConsumingDistributionSplit - 1] != 1 {
+		t.Error("v2接口时延分布不符", v2.TimeConsumingDistribution)
+	}
+}
+
+func TestResolution(t *testing.T) {
+	outputs := make(chan *OutPutData, 10)
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "降采样测试",
+		Resolutions: []time.Duration {time.Hour},
+		ResolutionOutputCaller: func(o *OutPutData) {
+			outputs <- o
+		},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 5分钟一个周期，0点的12个周期中第3个周期没有调用，第13个周期到来时输出0点的窗口
+	for i := 1; i <= 13; i++ {
+		outputData := testOutputData("GET - 测试接口", 100, 90, 80, start.Add(time.Duration(i) * 5 * time.Minute))
+		if i == 3 {
+			outputData = OutPutData {InterfaceName: "GET - 测试接口", Timestamp: outputData.Timestamp}
+		} else {
+			outputData.MinMs, outputData.MaxMs = uint32(i), uint32(100 + i)
+			outputData.successMsCount = 1000
+			outputData.FailDistribution = map[string]uint32 {"code[500]": 10}
+			outputData.TimeConsumingDistribution = map[string]uint32 {"<100": 90}
+		}
+		c.downsample(&outputData)
+	}
+	select {
+	case o := <-outputs:
+		if o.Resolution != "1h" || !o.Timestamp.Equal(start) || o.Count != 1100 || o.SuccessRate != 0.9 || o.FastRate != 0.8 {
+			t.Error("降采样数据不符", o.Resolution, o.Timestamp, o.Count, o.SuccessRate, o.FastRate)
+		}
+		if o.MinMs != 1 || o.MaxMs != 112 || o.SuccessMsAver != 10 || o.FailDistribution["code[500]"] != 110 || o.TimeConsumingDistribution["<100"] != 990 {
+			t.Error("降采样耗时与分布不符", o.MinMs, o.MaxMs, o.SuccessMsAver, o.FailDistribution, o.TimeConsumingDistribution)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("没有输出降采样数据")
+	}
+}
+
+func TestOutputHistory(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "历史数据测试",
+		HistorySize: 3,
+	})
+	if _, ok := c.Latest("GET - 测试接口"); ok {
+		t.Error("没有数据的条目不应有最近数据")
+	}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		c.outputHistory.add(testOutputData("GET - 测试接口", uint32(i + 1), 0, 0, start.Add(time.Duration(i) * time.Minute)))
+	}
+	history := c.History("GET - 测试接口", time.Time {}, time.Time {})
+	if len(history) != 3 || history[0].Count != 3 || history[2].Count != 5 {
+		t.Error("历史数据不符", history)
+	}
+	if history := c.History("GET - 测试接口", start.Add(3 * time.Minute), start.Add(4 * time.Minute)); len(history) != 1 || history[0].Count != 4 {
+		t.Error("按时间范围查询的历史数据不符", history)
+	}
+	if latest, ok := c.Latest("GET - 测试接口"); !ok || latest.Count != 5 {
+		t.Error("最近数据不符", latest)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "快照测试",
+		Rollups: []Rollup {{Name: "全部接口"}},
+	})
+	c.Report("GET - /api/users", 10, 200)
+	c.Report("GET - /api/users", 20, 500)
+	c.Report("GET - /api/orders", 30, 200)
+	snapshot := c.Snapshot()
+	if len(snapshot) != 3 {
+		t.Fatal("快照条目个数不符", len(snapshot))
+	}
+	orders, users, all := snapshot[0], snapshot[1], snapshot[2]
+	if orders.InterfaceName != "GET - /api/orders" || orders.Count != 1 || orders.SuccessMsAver != 30 {
+		t.Error("快照数据不符", orders)
+	}
+	if users.Count != 2 || users.SuccessRate != 0.5 || users.FailDistribution["code[500]"] != 1 {
+		t.Error("快照数据不符", users)
+	}
+	if all.InterfaceName != "全部接口" || all.Count != 3 {
+		t.Error("汇总条目快照数据不符", all)
+	}
+	// 快照不影响当前周期的累计
+	c.Report("GET - /api/orders", 30, 200)
+	if snapshot := c.Snapshot(); snapshot[0].Count != 2 || orders.Count != 1 {
+		t.Error("快照之后的累计不符", snapshot[0].Count, orders.Count)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "状态页测试",
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+	})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		outputData := testOutputData("GET - /api/<users>", 100, 50, 50, start.Add(time.Duration(i) * time.Minute))
+		outputData.TimeConsumingDistribution = map[string]uint32 {"<100": 30, "100~150": 20, ">500": 0}
+		outputData.FailDistribution = map[string]uint32 {"code[500]": 50}
+		c.outputHistory.add(outputData)
+		analyze(c, outputData.InterfaceName, outputData)
+	}
+	server := httptest.NewServer(c.Registry.DashboardHandler())
+	defer server.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := get("/")
+	if code != http.StatusOK || !strings.Contains(body, "状态页测试") || !strings.Contains(body, "GET - /api/&lt;users&gt;") || !strings.Contains(body, "访问成功率") {
+		t.Error("状态页首页不符", code, body)
+	}
+	code, body = get("/entry?client=" + url.QueryEscape("状态页测试") + "&entry=" + url.QueryEscape("GET - /api/<users>"))
+	if code != http.StatusOK || strings.Index(body, "&lt;100") > strings.Index(body, "100~150") || !strings.Contains(body, "code[500]") {
+		t.Error("条目详情页不符", code, body)
+	}
+	if code, _ = get("/entry?client=" + url.QueryEscape("状态页测试") + "&entry=none"); code != http.StatusNotFound {
+		t.Error("不存在的条目应返回404", code)
+	}
+	if code, body = get("/static/style.css"); code != http.StatusOK || !strings.Contains(body, ".sparkline") {
+		t.Error("静态资源不符", code)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "接口/测试",
+		Labels: map[string]string {"team": "api"},
+		AlertCaller: func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {},
+	})
+	c.AddEntryConfig("GET - /api/users", EntryConfig {SuccessRate: 0.99})
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		outputData := testOutputData("GET - /api/users", 100, 50, 50, start.Add(time.Duration(i) * time.Minute))
+		c.outputHistory.add(outputData)
+		analyze(c, outputData.InterfaceName, outputData)
+	}
+	server := httptest.NewServer(c.Registry.APIHandler("/monitor/api/"))
+	defer server.Close()
+	clientPath := "/monitor/api/clients/" + url.PathEscape("接口/测试")
+	entryQuery := "?entry=" + url.QueryEscape("GET - /api/users")
+	request := func(method string, path string, body string, status int, v interface {}) {
+		req, _ := http.NewRequest(method, server.URL + path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Error("状态码不符", method, path, resp.StatusCode)
+			return
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Error("响应格式错误", method, path, err)
+			}
+		}
+	}
+	var clients []apiClient
+	request("GET", "/monitor/api/clients", "", http.StatusOK, &clients)
+	found := false
+	for _, client := range clients {
+		if client.Name == "接口/测试" {
+			found = client.Labels["team"] == "api" && client.Entries == 1 && client.Alerts == 2
+		}
+	}
+	if !found {
+		t.Error("客户端列表不符", clients)
+	}
+	var entries []apiEntry
+	request("GET", clientPath + "/entries", "", http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Latest == nil || len(entries[0].Alerts) != 2 {
+		t.Error("条目列表不符", entries)
+	}
+	var latest OutPutData
+	request("GET", clientPath + "/latest" + entryQuery, "", http.StatusOK, &latest)
+	if !latest.Timestamp.Equal(start.Add(2 * time.Minute)) {
+		t.Error("最近数据不符", latest)
+	}
+	var history []OutPutData
+	request("GET", clientPath + "/history" + entryQuery + "&from=" + url.QueryEscape(start.Add(time.Minute).Format(time.RFC3339)), "", http.StatusOK, &history)
+	if len(history) != 2 {
+		t.Error("历史数据不符", len(history))
+	}
+	request("GET", clientPath + "/history" + entryQuery + "&from=yesterday", "", http.StatusBadRequest, nil)
+	var config EntryConfig
+	request("GET", clientPath + "/config" + entryQuery, "", http.StatusOK, &config)
+	if config.SuccessRate != 0.99 || config.FastRate != 0.8 || config.AlertForBadSuccessRateReachedTimes != 3 {
+		t.Error("条目配置不符", config)
+	}
+	var alerts []ActiveAlert
+	request("GET", clientPath + "/alerts", "", http.StatusOK, &alerts)
+	if len(alerts) != 2 || alerts[0].AlertType != FAIL || alerts[0].Severity != CRITICAL {
+		t.Error("告警列表不符", alerts)
+	}
+	var silence Silence
+	request("POST", clientPath + "/silences", `{"entryPattern": "GET - *", "endsAt": "2100-01-01T00:00:00Z", "comment": "发布"}`, http.StatusCreated, &silence)
+	if silence.ID == "" || silence.Comment != "发布" {
+		t.Error("添加静默不符", silence)
+	}
+	request("POST", clientPath + "/silences", `{"endsAt": "2000-01-01T00:00:00Z", "startsAt": "2001-01-01T00:00:00Z"}`, http.StatusBadRequest, nil)
+	var silences []Silence
+	request("GET", clientPath + "/silences", "", http.StatusOK, &silences)
+	if len(silences) != 1 {
+		t.Error("静默列表不符", silences)
+	}
+	request("DELETE", clientPath + "/silences/" + silence.ID, "", http.StatusNoContent, nil)
+	request("DELETE", clientPath + "/silences/" + silence.ID, "", http.StatusNotFound, nil)
+	request("GET", "/monitor/api/clients/none/alerts", "", http.StatusNotFound, nil)
+	request("GET", clientPath + "/unknown", "", http.StatusNotFound, nil)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	c := Register(ReportClientConfig {Name: "注册表测试", StatisticalCycle: 300000, Registry: registry})
+	defer Register(ReportClientConfig {Name: "注册表测试2", StatisticalCycle: 300000, Registry: registry}).Close()
+	if found, ok := registry.Lookup("注册表测试"); !ok || found != c {
+		t.Error("按名称查找客户端失败")
+	}
+	if _, ok := Lookup("注册表测试"); ok {
+		t.Error("默认注册表中不应存在该客户端")
+	}
+	if all := registry.All(); len(all) != 2 || all[0] != c {
+		t.Error("客户端列表不符", all)
+	}
+	// 名称重复时新的客户端替代原有的客户端，原有的客户端被关闭
+	replaced := New("注册表测试", WithStatisticalCycle(time.Minute), WithRegistry(registry))
+	if all := registry.All(); len(all) != 2 || all[0] != replaced {
+		t.Error("重复注册的客户端应替代原有的客户端", all)
+	}
+	select {
+	case <-c.(*ReportClientConfig).done:
+	default:
+		t.Error("被替代的客户端应当关闭")
+	}
+	// Register与New的处理相同
+	duplicate := Register(ReportClientConfig {Name: "注册表测试", StatisticalCycle: 300000, Registry: registry})
+	if found, _ := registry.Lookup("注册表测试"); found != duplicate || len(registry.All()) != 2 {
+		t.Error("重复注册的客户端应替代原有的客户端")
+	}
+	// 需要拒绝重复的名称时通过注册表注册，不会创建客户端，也不会替代原有的客户端
+	if rejected, err := registry.Register(ReportClientConfig {Name: "注册表测试", StatisticalCycle: 300000}); rejected != nil || err == nil || err.Error() != "name: 客户端名称已被注册：注册表测试" {
+		t.Error("注册表应当拒绝重复的名称", err)
+	}
+	if found, _ := registry.Lookup("注册表测试"); found != duplicate || len(registry.All()) != 2 {
+		t.Error("拒绝的客户端不应影响注册表")
+	}
+	// 被替代的客户端关闭时不影响注册表
+	replaced.Close()
+	if found, _ := registry.Lookup("注册表测试"); found != duplicate {
+		t.Error("被替代的客户端不应注销新的客户端")
+	}
+	duplicate.Close()
+	duplicate.Close()
+	if _, ok := registry.Lookup("注册表测试"); ok || len(registry.All()) != 1 {
+		t.Error("关闭之后应从注册表中注销")
+	}
+	// 关闭之后的上报与快照不应阻塞
+	for i := 0; i < 200; i++ {
+		c.Report("GET - /api/users", 10, 200)
+	}
+	if snapshot := c.Snapshot(); len(snapshot) != 0 {
+		t.Error("关闭之后的快照应为空", snapshot)
+	}
+	// 注销之后名称可以再次注册
+	Register(ReportClientConfig {Name: "注册表测试", StatisticalCycle: 300000, Registry: registry}).Close()
+	if registered, err := registry.Register(ReportClientConfig {Name: "注册表测试", StatisticalCycle: 300000}); err != nil {
+		t.Error(err)
+	} else {
+		registered.Close()
+	}
+}
+
+func TestConfigLoader(t *testing.T) {
+	alerted := 0
+	loader := &ConfigLoader {
+		Alerts: map[string]func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			"count": func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+				alerted++
+			},
+		},
+		Receivers: map[string]Receiver {
+			"oncall": func(n *Notification) {},
+			"log": func(n *Notification) {},
+		},
+		Registry: NewRegistry(),
+	}
+	clients, err := loader.Parse([]byte(`{
+		"alertManagers": {
+			"team": {
+				"defaultReceiver": "log",
+				"groupWait": "30s",
+				"routes": [{"clientName": "配置*", "routes": [{"severities": ["CRITICAL"], "receiver": "oncall"}]}],
+				"inhibitRules": [{"source": {"alertTypes": ["FAIL"]}, "target": {"alertTypes": ["SLOW"]}, "equal": ["interfaceName"]}]
+			}
+		},
+		"clients": [{
+			"name": "配置测试",
+			"alertManager": "team",
+			"labels": {"team": "web"},
+			"statisticalCycle": "5m",
+			"successRate": 0.99,
+			"codeFeatureMap": {"200": {"success": true}, "404": {"success": true, "name": "未找到"}},
+			"alert": "count",
+			"output": "console",
+			"repeatInterval": "30m",
+			"resolutions": ["1h"],
+			"rollups": [{"name": "全部接口"}],
+			"maintenanceWindows": [{"weekdays": ["Tuesday"], "start": "2h", "duration": "2h", "alertTypes": ["SLOW"]}],
+			"entries": {
+				"GET - /api/users": {
+					"fastLessThan": 200,
+					"alertWindow": 5,
+					"slo": {"availability": 0.999, "window": "168h", "burnRateRules": [{"longWindow": "1h", "shortWindow": "5m", "burnRate": 14.4, "severity": "warning"}]}
+				}
+			}
+		}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+	c := clients[0].(*ReportClientConfig)
+	if c.Name != "配置测试" || c.StatisticalCycle != 300000 || c.SuccessRate != 0.99 || c.RepeatInterval != 30 * time.Minute ||
+		c.Resolutions[0] != time.Hour || c.CodeFeatureMap[404].Name != "未找到" || c.OutputCaller != nil || c.Labels["team"] != "web" ||
+		c.MaintenanceWindows[0].Weekdays[0] != time.Tuesday || c.MaintenanceWindows[0].AlertTypes[0] != SLOW {
+		t.Error("客户端配置不符", c)
+	}
+	// 配置文件中声明的告警管理器
+	if m := c.AlertManager; m == nil || m.GroupWait != 30 * time.Second || len(m.Receivers) != 2 || m.Routes[0].ClientName != "配置*" ||
+		m.Routes[0].Routes[0].Severities[0] != CRITICAL || m.InhibitRules[0].Source.AlertTypes[0] != FAIL || m.InhibitRules[0].Equal[0] != "interfaceName" {
+		t.Error("告警管理器配置不符", c.AlertManager)
+	}
+	c.AlertCaller("", "", FAIL, nil)
+	if alerted != 1 {
+		t.Error("未按名称引用告警通知")
+	}
+	if found, ok := loader.Registry.Lookup("配置测试"); !ok || found != clients[0] {
+		t.Error("客户端未注册到指定的注册表")
+	}
+	entry := c.EffectiveEntryConfig("GET - /api/users")
+	if entry.FastLessThan != 200 || entry.AlertWindow != 5 || entry.SuccessRate != 0.99 || entry.SLO.Window != 168 * time.Hour || entry.SLO.BurnRateRules[0].Severity != WARNING {
+		t.Error("条目配置不符", entry)
+	}
+
+	// 错误信息指出出错的配置项
+	errors := []struct {
+		config string
+		err string
+	} {
+		{`{"clients": [{"name": "a"}, {"name": "b", "entries": {"GET - /b": {"fastLessThen": 200}}}]}`, `clients[1].entries["GET - /b"].fastLessThen: 未知的配置项`},
+		{`{"clients": [{"name": "a", "entries": {"GET - /a": {"successRate": 1.5}}}]}`, `clients[0].entries["GET - /a"].successRate: 成功率与高效访问率阈值必须介于0和1之间`},
+		{`{"clients": [{"name": "a", "entries": {"e": {"anomaly": {"season": "30m"}}}}]}`, `clients[0].entries["e"].anomaly.season: 季节周期不能小于时间段的长度`},
+		{`{"clients": [{"name": "a", "entries": {"e": {"slo": {"burnRateRules": [{"burnRate": 0}]}}}}]}`, `clients[0].entries["e"].slo.burnRateRules[0]: 燃烧率规则的窗口和阈值必须大于0`},
+		{`{"clients": [{"name": "a", "severityCallers": {"WARNING": "pager", "CRITICAL": "sms"}}]}`, `clients[0].severityCallers.CRITICAL: 未定义的告警事件处理：sms`},
+		{`{"clients": [{"name": "a", "entries": {"GET - /a": {"timeConsumingDistributionMin": 50, "timeConsumingDistributionMax": 55, "timeConsumingDistributionSplit": 20}}}]}`, `clients[0].entries["GET - /a"].timeConsumingDistributionMax: 耗时最长值与最短值之差不能小于区间个数减2`},
+		{`{"clients": [{"name": "a", "maintenanceWindows": [{"strt": "2h"}]}]}`, `clients[0].maintenanceWindows[0].strt: 未知的配置项`},
+		{`{"clients": [{"name": "a", "entries": {"e": {"slo": {"windw": "1h"}}}}]}`, `clients[0].entries["e"].slo.windw: 未知的配置项`},
+		{`{"clients": [{"name": "a", "entries": {"e": {"slo": {"burnRateRules": [{"burnRate": "fast"}]}}}}]}`, `clients[0].entries["e"].slo.burnRateRules[0].burnRate: 类型错误，应为float64`},
+		{`{"clients": [{"name": "a", "entries": {"e": {"anomaly": {"deviaton": 3}}}}]}`, `clients[0].entries["e"].anomaly.deviaton: 未知的配置项`},
+		{`{"clients": [{"name": "a", "rollups": [{"name": "全部", "patterns": ["*"]}]}]}`, `clients[0].rollups[0].patterns: 未知的配置项`},
+		{`{"clients": [{"name": "a", "codeFeatureMap": {"404": {"sucess": true}}}]}`, `clients[0].codeFeatureMap["404"].sucess: 未知的配置项`},
+		{`{"alertManagers": {"m": {"routes": [{"routes": [{"receiver": "pager"}]}]}}, "clients": []}`, `alertManagers["m"].routes[0].routes[0].receiver: 未定义的接收者：pager`},
+		{`{"alertManagers": {"m": {"inhibitRules": [{"source": {"severity": "CRITICAL"}}]}}, "clients": []}`, `alertManagers["m"].inhibitRules[0].source.severity: 未知的配置项`},
+		{`{"clients": [{"name": "a", "successRate": "high"}]}`, `clients[0].successRate: 类型错误，应为float64`},
+		{`{"clients": [{"name": "a", "flapWindow": "1 hour"}]}`, `clients[0].flapWindow: 无法解析的时长：1 hour`},
+		{`{"clients": [{"name": "a", "recover": "mail"}]}`, `clients[0].recover: 未定义的告警通知：mail`},
+		{`{"clients": [{"name": "a", "resolutions": ["30s"]}]}`, `clients[0].resolutions[0]: 降采样的粒度必须大于统计周期`},
+		{`{"clients": [{"name": "a"}, {"name": "a"}]}`, `clients[1].name: 客户端名称重复：a`},
+		{`{"clients": [{"name": "配置测试"}]}`, `clients[0].name: 客户端名称已被注册：配置测试`},
+		{"{\n\"clients\": [\n\t{\"name\": \"a\",}]}", `第3行第15列格式错误：invalid character '}' looking for beginning of object key string`},
+	}
+	for _, e := range errors {
+		_, err := loader.Parse([]byte(e.config))
+		if err == nil || err.Error() != e.err {
+			t.Error("错误信息不符", err)
+		}
+	}
+	if len(loader.Registry.All()) != 1 {
+		t.Error("配置有误时不应创建任何客户端")
+	}
+}
+
+func TestConfigLoaderYAML(t *testing.T) {
+	// 与JSON格式的配置等价
+	config := `
+# 告警管理器
+alertManagers:
+  team:
+    defaultReceiver: log
+    groupWait: 30s
+    routes:
+    - clientName: "配置*"
+      routes: [{severities: [CRITICAL], receiver: oncall}]
+clients:
+  - name: 'YAML配置''测试'
+    labels: {team: web, port: "8080"}
+    statisticalCycle: 5m   # 统计周期
+    successRate: 0.99
+    codeFeatureMap:
+      "404":
+        success: true
+        name: "未找到 #1"
+    resolutions: [1h]
+    rollups: []
+    entries:
+      GET - /api/users:
+        fastLessThan: 200
+        slo:
+          availability: 0.999
+          burnRateRules:
+            - longWindow: 1h
+              shortWindow: 5m
+              burnRate: 14.4
+`
+	expected := `{
+		"alertManagers": {"team": {"defaultReceiver": "log", "groupWait": "30s", "routes": [{"clientName": "配置*", "routes": [{"severities": ["CRITICAL"], "receiver": "oncall"}]}]}},
+		"clients": [{
+			"name": "YAML配置'测试",
+			"labels": {"team": "web", "port": "8080"},
+			"statisticalCycle": "5m",
+			"successRate": 0.99,
+			"codeFeatureMap": {"404": {"success": true, "name": "未找到 #1"}},
+			"resolutions": ["1h"],
+			"rollups": [],
+			"entries": {"GET - /api/users": {"fastLessThan": 200, "slo": {"availability": 0.999, "burnRateRules": [{"longWindow": "1h", "shortWindow": "5m", "burnRate": 14.4}]}}}
+		}]
+	}`
+	data, err := yamlToJSON([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual, wanted interface {}
+	json.Unmarshal(data, &actual)
+	json.Unmarshal([]byte(expected), &wanted)
+	if !reflect.DeepEqual(actual, wanted) {
+		t.Error("YAML转换的结果不符", string(data))
+	}
+	// 按扩展名选择格式
+	path := t.TempDir() + "/monitor.yml"
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loader := &ConfigLoader {Receivers: map[string]Receiver {"oncall": func(n *Notification) {}, "log": func(n *Notification) {}}, Registry: NewRegistry()}
+	clients, err := loader.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+	if c := clients[0].(*ReportClientConfig); c.Name != "YAML配置'测试" || c.StatisticalCycle != 300000 || c.EffectiveEntryConfig("GET - /api/users").FastLessThan != 200 {
+		t.Error("客户端配置不符", c)
+	}
+	if changes, err := loader.Reload(path); err != nil || len(changes) != 0 {
+		t.Error("配置未修改时不应有变化", changes, err)
+	}
+
+	// 错误信息指出出错的行或配置项
+	errors := []struct {
+		config string
+		err string
+	} {
+		{"clients:\n  - name: a\n    sucessRate: 0.9", `clients[0].sucessRate: 未知的配置项`},
+		{"clients:\n  - name: a\n    successRate: high", `clients[0].successRate: 类型错误，应为float64`},
+		{"clients:\n  - name: a\n      labels: {}", `第3行格式错误：缩进不一致`},
+		{"clients:\n  - name: a\n    labels", `第3行格式错误：应为"键: 值"的形式：labels`},
+		{"clients:\n  - name: \"a\n", `第2行格式错误：缺少结束的引号："a`},
+		{"clients:\n  - name: a\n    labels: {team: web\n", `第3行格式错误：缺少}：{team: web`},
+		{"clients:\n  - name: &a a\n", `第2行格式错误：不支持的语法：&a a`},
+		{"clients: []\nclients: []", `第2行格式错误：重复的键：clients`},
+		{"clients:\n\t- name: a", `第2行格式错误：不能使用制表符缩进`},
+	}
+	for _, e := range errors {
+		_, err := loader.ParseYAML([]byte(e.config))
+		if err == nil || err.Error() != e.err {
+			t.Error("错误信息不符", err)
+		}
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	outputs := make(chan *OutPutData, 1)
+	c := registerTestClient(t, ReportClientConfig {
+		Name: "配置更新测试",
+		FastRate: 0.9,
+		AlertForBadSuccessRateReachedTimes: 5,
+		OutputCaller: func(o *OutPutData) {
+			outputs <- o
+		},
+	})
+	c.AddEntryConfig("GET - /api/users", EntryConfig {TimeConsumingDistributionSplit: 5})
+	c.serverTask(&reportServer {Name: "GET - /api/users", Ms: 100, Code: 200})
+	successRate := 0.99
+	changes, err := c.UpdateConfig(ConfigUpdate {
+		Thresholds: &ThresholdsUpdate {SuccessRate: &successRate},
+		Entries: map[string]EntryConfig {"GET - /api/users": {TimeConsumingDistributionSplit: 8, SuccessRate: 0.9}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string {"successRate:0.95->0.99", "GET - /api/users.successRate:0->0.9", "GET - /api/users.timeConsumingDistributionSplit:5->8"}
+	if len(changes) != len(expected) {
+		t.Fatal("发生变化的配置项不符", changes)
+	}
+	for i, change := range changes {
+		key := change.Key
+		if change.InterfaceName != "" {
+			key = change.InterfaceName + "." + key
+		}
+		if s := fmt.Sprintf("%s:%v->%v", key, change.Old, change.New); s != expected[i] || change.ClientName != "配置更新测试" {
+			t.Error("发生变化的配置项不符", s)
+		}
+	}
+	// 新增的条目只列出实际设置的配置项
+	changes, err = c.UpdateConfig(ConfigUpdate {Entries: map[string]EntryConfig {"GET - /api/orders": {FastLessThan: 200}}})
+	if err != nil || len(changes) != 1 || changes[0].Key != "fastLessThan" || changes[0].Old != 500.0 || changes[0].New != 200.0 {
+		t.Error("新增条目的配置项变化不符", changes)
+	}
+	// 只更新指定的告警阈值，其余保持注册时的配置
+	if config := c.EffectiveEntryConfig("GET - /api/users"); config.SuccessRate != 0.9 || config.FastRate != 0.9 || config.AlertForBadSuccessRateReachedTimes != 5 {
+		t.Error("更新之后的配置不符", config)
+	}
+	// 当前周期仍按原配置统计
+	if c.collectDataMap["GET - /api/users"].Config.TimeConsumingDistributionSplit != 5 {
+		t.Error("新的配置不应在当前周期生效")
+	}
+	c.clearTask(&clearData {Time: time.Now()})
+	o := <-outputs
+	if len(o.TimeConsumingDistribution) != 5 || o.thresholds.SuccessRate != 0.95 {
+		t.Error("当前周期的数据应按原配置输出", o.TimeConsumingDistribution)
+	}
+	// 下一个周期开始时生效，并按新的区间重建分布统计
+	collectData := c.collectDataMap["GET - /api/users"]
+	if collectData.Config.TimeConsumingDistributionSplit != 8 || len(collectData.TimeConsumingDistribution) != 8 || c.cycleThresholds.SuccessRate != 0.99 {
+		t.Error("新的配置未在下一个周期生效")
+	}
+	fastRate := 2.0
+	if _, err := c.UpdateConfig(ConfigUpdate {Thresholds: &ThresholdsUpdate {FastRate: &fastRate}}); err == nil || err.Error() != "fastRate: 成功率与高效访问率阈值必须介于0和1之间" {
+		t.Error("不合法的阈值应当返回错误", err)
+	}
+	// 区间跨度取整之后除不尽时，超出的耗时归入最后一个中间区间
+	if _, err := c.UpdateConfig(ConfigUpdate {Entries: map[string]EntryConfig {"GET - /api/users": {TimeConsumingDistributionMin: 50, TimeConsumingDistributionMax: 55, TimeConsumingDistributionSplit: 5}}}); err != nil {
+		t.Fatal(err)
+	}
+	c.clearTask(&clearData {Time: time.Now()})
+	c.serverTask(&reportServer {Name: "GET - /api/users", Ms: 54, Code: 200})
+	if distribution := c.collectDataMap["GET - /api/users"].TimeConsumingDistribution; len(distribution) != 5 || distribution[3] != 1 {
+		t.Error("耗时应归入最后一个中间区间", distribution)
+	}
+	// 区间跨度为0时拒绝更新，避免统计时除零
+	if _, err := c.UpdateConfig(ConfigUpdate {Entries: map[string]EntryConfig {"GET - /api/users": {TimeConsumingDistributionMin: 50, TimeConsumingDistributionMax: 55, TimeConsumingDistributionSplit: 20}}}); err == nil || err.Error() != `entries["GET - /api/users"].timeConsumingDistributionMax: 耗时最长值与最短值之差不能小于区间个数减2` {
+		t.Error("不合法的分布区间应当返回错误", err)
+	}
+	// 校验失败时不更新任何配置
+	if _, err := c.UpdateConfig(ConfigUpdate {Thresholds: thresholdsUpdateOf(Thresholds {SuccessRate: 0.5}), Entries: map[string]EntryConfig {"GET - /api/users": {SLO: &SLOConfig {Availability: 1}}}}); err == nil || err.Error() != `entries["GET - /api/users"].slo.availability: SLO目标必须介于0和1之间` {
+		t.Error("不合法的SLO目标应当返回错误", err)
+	}
+	if c.currentThresholds().SuccessRate != 0.99 || c.EffectiveEntryConfig("GET - /api/users").TimeConsumingDistributionMax != 55 {
+		t.Error("校验失败时不应更新配置")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := t.TempDir() + "/monitor.json"
+	write := func(successRate string) {
+		config := `{"clients": [{"name": "重新加载测试", "statisticalCycle": "5m", "successRate": ` + successRate + `, "entries": {"GET - /api/users": {"fastLessThan": 200}}}, {"name": "重新加载测试2", "statisticalCycle": "5m"}]}`
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("0.99")
+	reloaded := make(chan []ConfigChange, 1)
+	loader := &ConfigLoader {
+		Registry: NewRegistry(),
+		ReloadCaller: func(changes []ConfigChange, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			reloaded <- changes
+		},
+	}
+	clients, err := loader.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clients[0].Close()
+	defer clients[1].Close()
+	if changes, err := loader.Reload(path); err != nil || len(changes) != 0 {
+		t.Error("配置未修改时不应有变化", changes, err)
+	}
+	stop := loader.Watch(path, 10 * time.Millisecond)
+	defer stop()
+	// 保证修改时间发生变化
+	time.Sleep(20 * time.Millisecond)
+	write("0.9")
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changes := <-reloaded:
+		if len(changes) != 1 || changes[0].Key != "successRate" || changes[0].New != 0.9 {
+			t.Error("重新加载的变化不符", changes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("配置文件修改之后未重新加载")
+	}
+	if config := clients[0].EffectiveEntryConfig("GET - /api/users"); config.SuccessRate != 0.9 || config.FastLessThan != 200 {
+		t.Error("重新加载之后的配置不符", config)
+	}
+	stop()
+	reload := func(config string) ([]ConfigChange, error) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return loader.Reload(path)
+	}
+	// 任何一个客户端的配置有误时都不更新任何配置
+	_, err = reload(`{"clients": [{"name": "重新加载测试", "successRate": 0.5}, {"name": "重新加载测试2", "entries": {"GET - /api/orders": {"anomaly": {"season": "30m"}}}}]}`)
+	if err == nil || err.Error() != `clients[1].entries["GET - /api/orders"].anomaly.season: 季节周期不能小于时间段的长度` {
+		t.Error("配置有误时应当返回错误", err)
+	}
+	if config := clients[0].EffectiveEntryConfig("GET - /api/users"); config.SuccessRate != 0.9 || config.FastLessThan != 200 {
+		t.Error("配置有误时不应更新任何客户端", config)
+	}
+	// 从配置文件中删除的条目恢复为默认的配置
+	changes, err := reload(`{"clients": [{"name": "重新加载测试", "successRate": 0.9}, {"name": "重新加载测试2"}]}`)
+	found := false
+	for _, change := range changes {
+		if change.InterfaceName != "GET - /api/users" {
+			t.Error("只有删除的条目应当发生变化", change)
+		}
+		found = found || change.Key == "fastLessThan" && change.Old == 200.0 && change.New == 500.0
+	}
+	if err != nil || !found {
+		t.Error("删除条目的变化不符", changes, err)
+	}
+	if config := clients[0].EffectiveEntryConfig("GET - /api/users"); config.FastLessThan != 500 {
+		t.Error("删除的条目未恢复为默认的配置", config)
+	}
+	if changes, err := loader.Reload(path); err != nil || len(changes) != 0 {
+		t.Error("条目已经恢复为默认的配置", changes, err)
+	}
+}
+
+// 手动推进的时钟
+type testClock struct {
+	now time.Time
+	tick chan time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {}
+}
+
+// 通过注入的时钟驱动真实的上报、统计、告警与通知流程
+type testPipeline struct {
+	t *testing.T
+	client ReportClient
+	clock *testClock
+	now time.Time
+	events chan *AlertEvent
+}
+
+// 创建客户端，全部告警事件都交给events，统计周期由cycle推进
+func newTestPipeline(t *testing.T, name string, opts ...Option) *testPipeline {
+	p := &testPipeline {
+		t: t,
+		clock: &testClock {now: time.Date(2018, 1, 1, 0, 0, 30, 0, time.UTC), tick: make(chan time.Time)},
+		now: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		events: make(chan *AlertEvent, 100),
+	}
+	opts = append([]Option {
+		WithStatisticalCycle(time.Minute),
+		WithOutput(func(o *OutPutData) {}),
+		WithEventCaller(func(e *AlertEvent) {
+			p.events <- e
+		}),
+		WithRegistry(NewRegistry()),
+		WithClock(p.clock),
+	}, opts...)
+	p.client = New(name, opts...)
+	t.Cleanup(p.client.Close)
+	return p
+}
+
+// 上报一个周期的数据之后推进时钟，每个周期都上报一次心跳，快照中的心跳被清空即说明收集模块已经处理完该周期
+func (p *testPipeline) cycle(report func(c ReportClient)) {
+	p.client.Report("心跳", 1, 200)
+	if report != nil {
+		report(p.client)
+	}
+	p.now = p.now.Add(time.Minute)
+	p.clock.tick <- p.now
+	p.eventually("统计周期未被处理", func() bool {
+		for _, o := range p.client.Snapshot() {
+			if o.InterfaceName == "心跳" && o.Count > 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 等待下一个告警事件，并校验其条目、告警类型与事件类型
+func (p *testPipeline) expect(interfaceName string, alertType AlertType, eventType EventType) *AlertEvent {
+	select {
+	case e := <-p.events:
+		if e.InterfaceName != interfaceName || e.AlertType != alertType || e.EventType != eventType {
+			p.t.Fatal("告警事件不符", e.InterfaceName, e.AlertType, e.EventType)
+		}
+		return e
+	case <-time.After(time.Second):
+		p.t.Fatal("未收到告警事件", interfaceName, alertType, eventType)
+	}
+	return nil
+}
+
+// 确认没有多余的告警事件
+func (p *testPipeline) expectNone() {
+	select {
+	case e := <-p.events:
+		p.t.Fatal("不应有告警事件", e.InterfaceName, e.AlertType, e.EventType)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// 等待条件成立，告警分析与通知在其他goroutine中进行
+func (p *testPipeline) eventually(message string, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			p.t.Fatal(message)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockedAlertCaller(t *testing.T) {
+	clock := &testClock {now: time.Date(2018, 1, 1, 0, 0, 30, 0, time.UTC), tick: make(chan time.Time)}
+	release := make(chan struct {})
+	alerts := make(chan string, 100)
+	recovers := make(chan string, 100)
+	client := New("通知阻塞测试",
+		WithStatisticalCycle(time.Minute),
+		WithChannelCacheCount(1),
+		WithAlertCaller(func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			<-release
+			alerts <- interfaceName
+		}),
+		WithRecoverCaller(func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			recovers <- interfaceName
+		}),
+		WithRegistry(NewRegistry()),
+		WithClock(clock),
+	)
+	defer client.Close()
+	finished := make(chan struct {})
+	go func() {
+		start := time.Date(2018, 1, 1, 0, 1, 0, 0, time.UTC)
+		// 前10个周期全部失败，之后全部成功
+		for i := 0; i < 20; i++ {
+			code := 500
+			if i >= 10 {
+				code = 200
+			}
+			for j := 0; j < 10; j++ {
+				client.Report("GET - /api/" + strconv.Itoa(j % 5), 10, code)
+			}
+			clock.tick <- start.Add(time.Duration(i) * time.Minute)
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("告警回调阻塞时上报不应被阻塞")
+	}
+	// 回调恢复之后，阻塞期间产生的告警与恢复事件一个都不能丢失
+	close(release)
+	for name, ch := range map[string]chan string {"告警": alerts, "恢复": recovers} {
+		received := map[string]bool {}
+		for len(received) < 5 {
+			select {
+			case interfaceName := <-ch:
+				if received[interfaceName] {
+					t.Error(name + "通知重复", interfaceName)
+				}
+				received[interfaceName] = true
+			case <-time.After(2 * time.Second):
+				t.Fatal(name + "通知丢失", received)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	clock := &testClock {now: time.Date(2018, 1, 1, 0, 0, 30, 0, time.UTC), tick: make(chan time.Time)}
+	outputs := make(chan *OutPutData, 1)
+	registry := NewRegistry()
+	client := New("选项测试",
+		WithStatisticalCycle(time.Minute),
+		WithThresholds(Thresholds {SuccessRate: 0.99, AlertWindow: 5}),
+		WithLabels(map[string]string {"team": "web"}),
+		WithOutput(func(o *OutPutData) {
+			outputs <- o
+		}),
+		WithSeverityCaller(WARNING, func(e *AlertEvent) {}),
+		WithSeverityCaller(CRITICAL, func(e *AlertEvent) {}),
+		WithRegistry(registry),
+		WithClock(clock),
+	)
+	defer client.Close()
+	c := client.(*ReportClientConfig)
+	if c.Name != "选项测试" || c.StatisticalCycle != 60000 || c.Labels["team"] != "web" || len(c.SeverityCallers) != 2 {
+		t.Error("客户端配置不符", c)
+	}
+	if config := client.EffectiveEntryConfig("GET - /api/users"); config.SuccessRate != 0.99 || config.FastRate != 0.8 || config.AlertWindow != 5 {
+		t.Error("告警阈值不符", config)
+	}
+	if _, ok := registry.Lookup("选项测试"); !ok {
+		t.Error("客户端未注册到指定的注册表")
+	}
+	client.Report("GET - /api/users", 10, 200)
+	if snapshot := client.Snapshot(); len(snapshot) != 1 || !snapshot[0].Timestamp.Equal(clock.now) {
+		t.Error("快照应使用客户端的时钟", snapshot)
+	}
+	// 统计周期由客户端的时钟驱动
+	cycleTime := time.Date(2018, 1, 1, 0, 1, 0, 0, time.UTC)
+	clock.tick <- cycleTime
+	select {
+	case o := <-outputs:
+		if !o.Timestamp.Equal(cycleTime) || o.Count != 1 {
+			t.Error("统计数据不符", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("时钟触发之后未输出统计数据")
+	}
+	// 兼容原有的注册方式
+	registered := Register(ReportClientConfig {Name: "选项测试", Registry: NewRegistry(), StatisticalCycle: 300000})
+	defer registered.Close()
+	if registered.(*ReportClientConfig).Name != "选项测试" {
+		t.Error("Register应当保留客户端名称")
+	}
+	// WithConfig只合并不为零值的配置，不清空之前的配置项
+	merged := New("选项测试", WithRegistry(NewRegistry()), WithStatisticalCycle(time.Minute), WithConfig(ReportClientConfig {SuccessRate: 0.9}), WithLabels(map[string]string {"team": "api"}))
+	defer merged.Close()
+	if c := merged.(*ReportClientConfig); c.StatisticalCycle != 60000 || c.SuccessRate != 0.9 || c.Labels["team"] != "api" || c.Registry == DefaultRegistry {
+		t.Error("WithConfig应当与其他配置项合并", c)
+	}
+}
+
+func TestEntryRetention(t *testing.T) {
+	p := newTestPipeline(t, "保留测试", WithNoDataAlert(2), WithEntryRetention(1), WithHistorySize(10))
+	entry := "GET - /api/items/1"
+	p.cycle(func(c ReportClient) {
+		c.Report(entry, 10, 200)
+	})
+	// 无数据告警发出之后再保留1个周期
+	p.cycle(nil)
+	p.cycle(nil)
+	p.expect(entry, NO_DATA, FIRING)
+	p.cycle(nil)
+	p.expectNone()
+	if len(p.client.Snapshot()) != 2 {
+		t.Error("保留期间不应移除条目")
+	}
+	// 超过保留的周期数之后移除条目，仍处于告警中的告警随之结束
+	p.cycle(nil)
+	p.expect(entry, NO_DATA, RESOLVED)
+	for _, o := range p.client.Snapshot() {
+		if o.InterfaceName == entry {
+			t.Error("条目应当被移除")
+		}
+	}
+	p.eventually("条目的告警状态应当被清除", func() bool {
+		return len(p.client.Alerts()) == 0
+	})
+	if history := p.client.History(entry, time.Time {}, time.Time {}); len(history) != 0 {
+		t.Error("条目的历史数据应当被移除", history)
+	}
+	// 移除之后不再流入分析，重新上报时视为新的条目
+	p.cycle(nil)
+	p.cycle(nil)
+	p.cycle(func(c ReportClient) {
+		c.Report(entry, 10, 200)
+	})
+	p.expectNone()
+	if history := p.client.History(entry, time.Time {}, time.Time {}); len(history) != 1 {
+		t.Error("重新上报的条目应当从头统计", history)
+	}
+}
+
+// 上报一个周期的调用，成功的返回200，失败的返回500，耗时均为10ms
+func reportResults(c ReportClient, name string, success int, fail int) {
+	for i := 0; i < success; i++ {
+		c.Report(name, 10, 200)
+	}
+	for i := 0; i < fail; i++ {
+		c.Report(name, 10, 500)
+	}
+}
+
+// 等待旧式回调被调用指定的次数，并校验调用的顺序
+func expectCalls(t *testing.T, calls chan string, expected ...string) {
+	deadline := time.After(time.Second)
+	for i, name := range expected {
+		select {
+		case call := <-calls:
+			if call != name {
+				t.Fatal("回调不符", i, call)
+			}
+		case <-deadline:
+			t.Fatal("回调次数不足", i)
+		}
+	}
+	select {
+	case call := <-calls:
+		t.Fatal("多余的回调", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPipelineBurnRateAlert(t *testing.T) {
+	p := newTestPipeline(t, "SLO流程测试", WithConfig(ReportClientConfig {SuccessRate: 0.01, FastRate: 0.01}))
+	entry := "GET - /api/orders"
+	p.client.AddEntryConfig(entry, EntryConfig {
+		SLO: &SLOConfig {
+			Availability: 0.99,
+			BurnRateRules: []BurnRateRule {{LongWindow: 10 * time.Minute, ShortWindow: time.Minute, BurnRate: 10}},
+		},
+	})
+	// 前5个周期全部成功，之后2个周期失败率80%，燃烧率达到阈值时立即告警，之后恢复
+	for i := 0; i < 10; i++ {
+		fail := 0
+		if i == 5 || i == 6 {
+			fail = 8
+		}
+		p.cycle(func(c ReportClient) {
+			reportResults(c, entry, 10 - fail, fail)
+		})
+		if i == 5 {
+			e := p.expect(entry, FAIL_BUDGET, FIRING)
+			if e.Severity != CRITICAL || e.RecentOutputData[0].ErrorBudget == nil || e.RecentOutputData[0].ErrorBudget.Availability.Remaining >= 0 {
+				t.Error("燃烧率告警事件不符", e.Severity, e.RecentOutputData[0].ErrorBudget)
+			}
+		}
+	}
+	e := p.expect(entry, FAIL_BUDGET, RESOLVED)
+	if !e.StartsAt.Equal(p.now.Add(-4 * time.Minute)) {
+		t.Error("燃烧率告警的开始时间不符", e.StartsAt)
+	}
+	p.expectNone()
+}
+
+func TestPipelineRepeatAndEscalation(t *testing.T) {
+	calls := make(chan string, 100)
+	caller := func(name string) func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+		return func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			calls <- name
+		}
+	}
+	p := newTestPipeline(t, "重复通知流程测试",
+		WithRepeatInterval(2 * time.Minute),
+		WithEscalation(5 * time.Minute, caller("escalate")),
+		WithAlertCaller(caller("alert")),
+		WithRepeatCaller(caller("repeat")),
+		WithRecoverCaller(caller("recover")),
+	)
+	entry := "GET - /api/orders"
+	// 第1个周期开始失败，第3分钟告警，第5分钟重复，第6分钟升级，第8分钟重复（同时发给升级对象），之后恢复
+	for i := 0; i < 11; i++ {
+		p.cycle(func(c ReportClient) {
+			if i < 8 {
+				reportResults(c, entry, 0, 10)
+			} else {
+				reportResults(c, entry, 10, 0)
+			}
+		})
+	}
+	expected := []EventType {FIRING, REPEAT, ESCALATE, REPEAT, RESOLVED}
+	times := []int {3, 5, 6, 8, 11}
+	for i, eventType := range expected {
+		e := p.expect(entry, FAIL, eventType)
+		if !e.Time.Equal(time.Date(2018, 1, 1, 0, times[i], 0, 0, time.UTC)) || e.Escalated != (i >= 2) {
+			t.Error("告警事件的时间或升级状态不符", i, e.Time, e.Escalated)
+		}
+	}
+	p.expectNone()
+	expectCalls(t, calls, "alert", "repeat", "escalate", "repeat", "escalate", "recover")
+}
+
+func TestPipelineSeverity(t *testing.T) {
+	calls := make(chan string, 100)
+	critical := make(chan EventType, 100)
+	p := newTestPipeline(t, "告警级别流程测试",
+		WithConfig(ReportClientConfig {SuccessRate: 0.5, WarningSuccessRate: 0.9, FastRate: 0.01}),
+		WithSeverityCaller(CRITICAL, func(e *AlertEvent) {
+			critical <- e.EventType
+		}),
+		WithAlertCaller(func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			calls <- "alert"
+		}),
+		WithRecoverCaller(func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			calls <- "recover"
+		}),
+	)
+	entry := "GET - /api/orders"
+	// 成功率70%为警告级别，20%为严重级别
+	for _, success := range []int {7, 7, 7, 7, 2, 2, 10, 10, 10} {
+		p.cycle(func(c ReportClient) {
+			reportResults(c, entry, success, 10 - success)
+		})
+	}
+	if e := p.expect(entry, FAIL, FIRING); e.Severity != WARNING {
+		t.Error("告警级别不符", e.Severity)
+	}
+	if e := p.expect(entry, FAIL, SEVERITY_CHANGED); e.Severity != CRITICAL || e.PrevSeverity != WARNING {
+		t.Error("级别变化事件不符", e.Severity, e.PrevSeverity)
+	}
+	if e := p.expect(entry, FAIL, RESOLVED); e.Severity != NORMAL || e.PrevSeverity != CRITICAL || e.Duration != 8 * time.Minute {
+		t.Error("恢复事件不符", e.Severity, e.PrevSeverity, e.Duration)
+	}
+	p.expectNone()
+	// 级别变化只交给对应级别的回调，旧式回调只在告警与恢复时各调用一次
+	if len(critical) != 1 || <-critical != SEVERITY_CHANGED {
+		t.Error("严重级别的回调不符")
+	}
+	expectCalls(t, calls, "alert", "recover")
+}
+
+func TestPipelineSilence(t *testing.T) {
+	p := newTestPipeline(t, "静默流程测试")
+	entry := "GET - /api/orders"
+	at := func(minute int) time.Time {
+		return time.Date(2018, 1, 1, 0, minute, 0, 0, time.UTC)
+	}
+	// 静默期间触发的告警在静默结束后补发，已发出的告警在静默期间恢复时，恢复通知同样在静默结束后补发
+	p.client.Silence(Silence {EntryPattern: "GET - /api/*", AlertTypes: []AlertType {FAIL}, StartsAt: at(2), EndsAt: at(5)})
+	p.client.Silence(Silence {EntryPattern: entry, StartsAt: at(7), EndsAt: at(10)})
+	for i := 0; i < 10; i++ {
+		p.cycle(func(c ReportClient) {
+			if i < 5 {
+				reportResults(c, entry, 0, 10)
+			} else {
+				reportResults(c, entry, 10, 0)
+			}
+		})
+		switch i {
+		case 4:
+			if e := p.expect(entry, FAIL, FIRING); !e.Time.Equal(at(5)) || !e.StartsAt.Equal(at(1)) {
+				t.Error("静默结束后补发的告警不符", e.Time, e.StartsAt)
+			}
+		case 9:
+			// 恢复通知的时间仍为实际恢复的时间
+			if e := p.expect(entry, FAIL, RESOLVED); !e.Time.Equal(at(8)) || e.Duration != 7 * time.Minute {
+				t.Error("静默结束后补发的恢复通知不符", e.Time)
+			}
+		default:
+			p.expectNone()
+		}
+	}
+}
+
+func TestPipelineFlapping(t *testing.T) {
+	flapping := make(chan string, 100)
+	p := newTestPipeline(t, "抖动流程测试",
+		WithNoDataAlert(1),
+		WithFlapping(4, 10 * time.Minute, func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData) {
+			flapping <- interfaceName
+		}),
+	)
+	entry := "GET - /api/orders"
+	// 前9个周期有无调用交替出现，第5分钟开始抖动，第17分钟窗口内只剩2次状态变化，视为稳定并补发恢复通知
+	for i := 0; i < 17; i++ {
+		p.cycle(func(c ReportClient) {
+			if i % 2 == 0 || i >= 8 {
+				reportResults(c, entry, 10, 0)
+			}
+		})
+	}
+	expected := []EventType {FIRING, RESOLVED, FIRING, FLAPPING, RESOLVED}
+	times := []int {2, 3, 4, 5, 17}
+	for i, eventType := range expected {
+		if e := p.expect(entry, NO_DATA, eventType); !e.Time.Equal(time.Date(2018, 1, 1, 0, times[i], 0, 0, time.UTC)) {
+			t.Error("告警事件的时间不符", i, e.Time)
+		}
+	}
+	p.expectNone()
+	if len(flapping) != 1 {
+		t.Error("抖动通知次数不符", len(flapping))
+	}
+}
@@ -0,0 +1,200 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 告警历史，记录告警、级别变化、恢复与抖动的状态变化，超过容量时淘汰最早的记录
+// 可以被多个客户端共享，未设置时每个客户端各自持有一份
+type AlertHistory struct {
+	// 最多保留多少条记录
+	size int
+	records []AlertRecord
+	// 使用方的查询与告警分析模块的记录可能同时发生，需要加锁
+	lock sync.Mutex
+}
+
+// 一条告警状态变化的记录
+type AlertRecord struct {
+	// 客户端命名
+	ClientName string `json:"clientName"`
+	// 接口命名
+	InterfaceName string `json:"interfaceName"`
+	// 告警类型
+	AlertType AlertType `json:"alertType"`
+	// 状态变化的类型，取值为FIRING、SEVERITY_CHANGED、RESOLVED或FLAPPING
+	EventType EventType `json:"eventType"`
+	// 告警级别
+	Severity Severity `json:"severity"`
+	// 告警开始时间
+	StartsAt time.Time `json:"startsAt"`
+	// 状态变化的时间
+	Time time.Time `json:"time"`
+	// 告警已经持续的时间，恢复记录中即为整个告警的持续时间
+	Duration time.Duration `json:"duration"`
+	// 是否发出了通知，处于静默中的告警不发出通知
+	Notified bool `json:"notified"`
+	// 触发状态变化的最近一个周期的数据摘要
+	Summary DataSummary `json:"summary"`
+}
+
+// 统计数据的摘要
+type DataSummary struct {
+	// 调用总次数
+	Count uint32 `json:"count"`
+	// 成功率
+	SuccessRate float64 `json:"successRate"`
+	// 时间达标率
+	FastRate float64 `json:"fastRate"`
+	// 成功平均耗时
+	SuccessMsAver uint32 `json:"successMsAver"`
+}
+
+// 告警历史的查询条件，各条件为空时表示不限制
+type AlertHistoryQuery struct {
+	// 客户端名称，支持*和?通配符
+	ClientName string
+	// 条目，支持*和?通配符
+	EntryPattern string
+	// 告警类型
+	AlertTypes []AlertType
+	// 起始时间（包含）
+	From time.Time
+	// 结束时间（不包含）
+	To time.Time
+}
+
+// 条目在一段时间内的告警统计
+type AlertStats struct {
+	// 客户端命名
+	ClientName string `json:"clientName"`
+	// 接口命名
+	InterfaceName string `json:"interfaceName"`
+	// 告警次数
+	AlertCount int `json:"alertCount"`
+	// 已恢复的告警次数
+	ResolvedCount int `json:"resolvedCount"`
+	// 已恢复告警的总持续时间
+	TotalDuration time.Duration `json:"totalDuration"`
+	// 已恢复告警的最长持续时间
+	LongestDuration time.Duration `json:"longestDuration"`
+	// 平均恢复时间
+	MTTR time.Duration `json:"mttr"`
+}
+
+// 创建告警历史，size为最多保留的记录数，默认1000
+func NewAlertHistory(size int) *AlertHistory {
+	if size <= 0 {
+		size = 1000
+	}
+	return &AlertHistory {size: size}
+}
+
+// 添加一条记录
+func (h *AlertHistory) Record(r AlertRecord) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.records = append(h.records, r)
+	if len(h.records) > h.size {
+		h.records = append(h.records[:0], h.records[len(h.records) - h.size:]...)
+	}
+}
+
+// 查询满足条件的记录，按时间先后排列
+func (h *AlertHistory) Query(q AlertHistoryQuery) []AlertRecord {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	records := []AlertRecord {}
+	for _, r := range h.records {
+		if q.match(&r) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// 按条目统计满足条件的记录中的告警次数与平均恢复时间
+func (h *AlertHistory) Stats(q AlertHistoryQuery) []AlertStats {
+	statsMap := map[string]*AlertStats {}
+	for _, r := range h.Query(q) {
+		key := r.ClientName + "\x00" + r.InterfaceName
+		stats, ok := statsMap[key]
+		if !ok {
+			stats = &AlertStats {ClientName: r.ClientName, InterfaceName: r.InterfaceName}
+			statsMap[key] = stats
+		}
+		switch r.EventType {
+		case FIRING:
+			stats.AlertCount++
+		case RESOLVED:
+			stats.ResolvedCount++
+			stats.TotalDuration += r.Duration
+			if r.Duration > stats.LongestDuration {
+				stats.LongestDuration = r.Duration
+			}
+		}
+	}
+	result := make([]AlertStats, 0, len(statsMap))
+	for _, stats := range statsMap {
+		if stats.ResolvedCount > 0 {
+			stats.MTTR = stats.TotalDuration / time.Duration(stats.ResolvedCount)
+		}
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ClientName != result[j].ClientName {
+			return result[i].ClientName < result[j].ClientName
+		}
+		return result[i].InterfaceName < result[j].InterfaceName
+	})
+	return result
+}
+
+// 记录是否满足查询条件
+func (q *AlertHistoryQuery) match(r *AlertRecord) bool {
+	if q.ClientName != "" && !matchPattern(q.ClientName, r.ClientName) {
+		return false
+	}
+	if !q.From.IsZero() && r.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !r.Time.Before(q.To) {
+		return false
+	}
+	return matchAlert(q.EntryPattern, q.AlertTypes, r.InterfaceName, r.AlertType)
+}
+
+// 查询本客户端的告警历史
+func (c *ReportClientConfig) AlertRecords(q AlertHistoryQuery) []AlertRecord {
+	q.ClientName = c.Name
+	return c.AlertHistory.Query(q)
+}
+
+// 统计本客户端各条目的告警次数与平均恢复时间
+func (c *ReportClientConfig) AlertStats(q AlertHistoryQuery) []AlertStats {
+	q.ClientName = c.Name
+	return c.AlertHistory.Stats(q)
+}
+
+// 记录告警状态的变化
+func (c *ReportClientConfig) recordAlert(status *alertStatus, alertType AlertType, entryName string, eventType EventType, outputData OutPutData) {
+	c.AlertHistory.Record(AlertRecord {
+		ClientName: c.Name,
+		InterfaceName: entryName,
+		AlertType: alertType,
+		EventType: eventType,
+		Severity: status.severity,
+		StartsAt: status.startsAt,
+		Time: outputData.Timestamp,
+		Duration: outputData.Timestamp.Sub(status.startsAt),
+		Notified: status.notified,
+		Summary: DataSummary {
+			Count: outputData.Count,
+			SuccessRate: outputData.SuccessRate,
+			FastRate: outputData.FastRate,
+			SuccessMsAver: outputData.SuccessMsAver,
+		},
+	})
+}
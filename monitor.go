@@ -2,6 +2,8 @@ package monitor
 
 import (
 	"os"
+	"sync"
+	"time"
 	"encoding/json"
 )
 
@@ -10,6 +12,19 @@ type (
 	AlertType uint8
 	// 队列任务类型枚举
 	TaskType uint8
+	// 告警事件类型枚举
+	EventType uint8
+	// 告警级别枚举
+	Severity uint8
+)
+
+const (
+	// 未达到告警级别
+	NORMAL Severity = iota
+	// 警告
+	WARNING
+	// 严重，未区分级别的告警均为严重级别
+	CRITICAL
 )
 
 const (
@@ -19,6 +34,32 @@ const (
 	FAIL
 	// 时间达标率告警
 	SLOW
+	// 可用性错误预算燃烧率告警
+	FAIL_BUDGET
+	// 时延错误预算燃烧率告警
+	SLOW_BUDGET
+	// 无数据告警
+	NO_DATA
+	// 调用量下降告警
+	TRAFFIC_DROP
+	// 偏离历史基线的异常告警
+	ANOMALY
+)
+
+const (
+	_ EventType = iota
+	// 触发告警
+	FIRING
+	// 告警持续期间的重复通知
+	REPEAT
+	// 告警持续超过一定时间后的升级通知
+	ESCALATE
+	// 告警持续期间级别发生变化
+	SEVERITY_CHANGED
+	// 告警恢复
+	RESOLVED
+	// 告警与恢复频繁交替，开始抖动，抖动期间不再逐一通知
+	FLAPPING
 )
 
 const (
@@ -27,6 +68,8 @@ const (
 	SERVER
 	// 清理任务，通常用于一个阶段的分析完成并清空旧数据
 	CLEAR
+	// 快照任务，拷贝当前周期截至目前的收集数据
+	SNAPSHOT
 )
 
 
@@ -36,12 +79,40 @@ type ReportClient interface {
 	Report(name string, ms uint32, code int)
 	// 添加自定义条目配置，包括条目对应的耗时达标标准以及时延分布等数据
 	AddEntryConfig(name string, entryConfig EntryConfig)
+	// 条目实际生效的配置
+	EffectiveEntryConfig(name string) EntryConfig
+	// 在运行时更新告警阈值与条目配置，下一个统计周期开始时生效，返回发生变化的配置项
+	UpdateConfig(u ConfigUpdate) ([]ConfigChange, error)
+	// 添加静默规则，静默期间匹配的告警不发出通知，返回静默ID
+	Silence(s Silence) string
+	// 删除静默规则
+	Unsilence(id string) bool
+	// 列出尚未结束的静默规则
+	Silences() []Silence
+	// 查询本客户端的告警历史，按条目和时间范围过滤
+	AlertRecords(q AlertHistoryQuery) []AlertRecord
+	// 统计本客户端各条目的告警次数与平均恢复时间
+	AlertStats(q AlertHistoryQuery) []AlertStats
+	// 查询条目在[from, to)时间范围内保留的统计数据，from或to为零值时表示不限制
+	History(entryName string, from time.Time, to time.Time) []OutPutData
+	// 条目最近一个周期的统计数据
+	Latest(entryName string) (OutPutData, bool)
+	// 当前周期截至目前的统计数据
+	Snapshot() []OutPutData
+	// 保留了统计数据的条目
+	Entries() []string
+	// 当前处于告警或抖动中的全部告警
+	Alerts() []ActiveAlert
+	// 关闭客户端，停止统计并从注册表中注销，之后的上报将被丢弃
+	Close()
 }
 
 // 客户端的全局配置，一个客户端可能会上报若干个接口
 type ReportClientConfig struct {
 	// 标识本上报的名称
 	Name string
+	// 客户端的标签，例如{"team": "db"}，随告警事件发出，可用于告警管理器的分组
+	Labels map[string]string
 	// 默认高速访问时间，根据调用系统的不同特性调整，优先匹配FastLessThan，剩余的则以此值作为依据，默认50ms
 	DefaultFastTime uint32
 	// 统计周期，默认为1分钟，不超过10分钟（避免周期过长，存储统计数据的变量溢出），单位ms
@@ -54,10 +125,37 @@ type ReportClientConfig struct {
 	AlertForGreatSuccessRateReachedTimes int
 	// 耗时连续达标多少个统计周期发出恢复报告，默认3
 	AlertForGreatFastRateReachedTimes	int
+	// 滑动窗口的周期数，设置后告警条件由“连续若干个周期不达标”改为“最近AlertWindow个周期中有若干个不达标”，恢复同理
+	// 例如AlertWindow为5时，最近5个周期中有3个成功率不达标即告警，告警之后最近5个周期中有3个达标即恢复，窗口小于所需周期数时按所需周期数计算
+	// 默认为0即连续模式，可被条目配置覆盖，错误预算告警不受影响
+	AlertWindow int
 	// 成功率多少以上算通过，1表示100%，默认0.95
 	SuccessRate	float64
 	// 高效访问率多少以上算通过，1表示100%，默认0.8
 	FastRate float64
+	// 成功率的警告阈值，应高于SuccessRate，低于该值为警告级别，低于SuccessRate为严重级别，默认为0即不区分级别
+	// 注意：告警级别及其变化只能通过EventCaller、SeverityCallers与告警管理器获知，AlertCaller等旧式回调不带级别，
+	// 只在告警首次发出（无论警告还是严重）与恢复时各调用一次，警告与严重之间的变化不会调用它们
+	WarningSuccessRate float64
+	// 高效访问率的警告阈值，应高于FastRate，含义同WarningSuccessRate
+	WarningFastRate float64
+	// 连续多少个统计周期没有上报数据发出无数据告警，有数据上报即恢复，默认为0即不启用
+	AlertForNoDataReachedTimes int
+	// 条目在没有上报数据之后保留多少个统计周期，启用无数据告警时从告警发出之后开始计算，超过之后移除条目的全部数据与告警状态，
+	// 仍处于告警中的告警随之结束并发出恢复通知，之后重新上报时视为新的条目，默认为0即一直保留，条目名称较多（例如带有参数）时应当启用
+	EntryRetentionCycles int
+	// 调用量相对基线下降多少算不达标，例如0.5表示下降一半及以上，默认为0即不启用调用量下降告警
+	TrafficDropRate float64
+	// 调用量基线取最近多少个正常统计周期的平均值，默认10
+	TrafficBaselineCycles int
+	// 调用量连续多少个统计周期不达标发出告警，以及告警之后连续多少个周期达标发出恢复报告，默认3
+	AlertForTrafficDropReachedTimes int
+	// 一个统计周期内调用次数少于该值时标记为数据不足，既不累计告警也不打断恢复，默认为0即不限制，可被条目配置覆盖
+	MinRequestCount int
+	// 以Wilson置信区间判定成功率与高效访问率是否达标的置信水平，例如0.95，只有置信区间上界仍低于阈值才认为不达标，默认为0即不启用
+	WilsonConfidence float64
+	// 汇总条目，每个统计周期将匹配的条目汇总为一个条目输出并参与告警，例如客户端的总计或者某个路径前缀下的全部接口
+	Rollups []Rollup
 	// 上报管道的缓存个数，默认为100
 	ChannelCacheCount int
 	// 判定code是否成功的依据，默认为 {200: { Success: true }}，取白名单机制，除此处定义的以外，统统认为失败。当然，如果有必要自定义Name属性，也可以定义一些失败的code
@@ -68,33 +166,109 @@ type ReportClientConfig struct {
 	DefaultFailDistributionFormat string
 	// 接受数据输出定制，默认输出到控制台
 	OutputCaller func(o *OutPutData)
-	// 告警处理方式定制，默认输出到控制台，目前alertType取值为FAIL代表成功率告警，SLOW代表耗时告警
+	// 每个条目在内存中保留最近多少个周期的统计数据，可通过History和Latest查询，默认60，小于0时不保留
+	HistorySize int
+	// 降采样的粒度，例如{5 * time.Minute, time.Hour, 24 * time.Hour}，每个粒度的窗口结束后合并输出窗口内的统计数据，必须大于统计周期，默认为空即不降采样
+	Resolutions []time.Duration
+	// 降采样数据的输出定制，数据中的Resolution为其粒度，默认输出到控制台
+	ResolutionOutputCaller func(o *OutPutData)
+	// 告警处理方式定制，默认输出到控制台，目前alertType取值为FAIL代表成功率告警，SLOW代表耗时告警，FAIL_BUDGET和SLOW_BUDGET代表对应SLO的错误预算燃烧率告警，NO_DATA代表无数据告警，TRAFFIC_DROP代表调用量下降告警，ANOMALY代表偏离历史基线的异常告警
 	AlertCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
 	// 恢复通知处理方式定制，同AlertCaller
 	RecoverCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
+	// 告警持续期间每隔多久重复通知一次，默认为0即不重复
+	RepeatInterval time.Duration
+	// 重复通知处理方式定制，参数同AlertCaller，重复通知不交给AlertCaller，以免被当作新的告警
+	// 未设置时，定制了AlertCaller的不再通知，否则输出到控制台，已升级的告警同时交给EscalationCaller
+	RepeatCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
+	// 告警持续多久之后升级通知，默认为0即不升级
+	EscalateAfter time.Duration
+	// 升级通知处理方式定制，参数同AlertCaller，告警升级之后的重复通知也将发给它，默认输出到控制台
+	EscalationCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
+	// 抖动通知处理方式定制，参数同AlertCaller，默认输出到控制台
+	FlappingCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)
+	// 在FlapWindow内告警与恢复的状态变化次数达到该值时判定为抖动，只发出一次抖动通知，直到状态变化次数不超过一半才恢复逐一通知，默认为0即不检测
+	FlapThreshold int
+	// 抖动检测的时间窗口，默认1小时
+	FlapWindow time.Duration
+	// 告警事件处理方式定制，接收告警、重复、升级、级别变化、恢复、抖动全部事件，事件中附带告警的持续时间、级别等信息，在AlertCaller等回调之外额外调用
+	EventCaller func(e *AlertEvent)
+	// 按告警级别分发告警事件，例如警告级别发送邮件，严重级别发送短信，在AlertCaller等回调之外额外调用，级别变化的事件只能通过它或EventCaller获知
+	SeverityCallers map[Severity]func(e *AlertEvent)
+	// 告警管理器，可以被多个客户端共享，按路由规则将告警事件分发给命名的接收者
+	AlertManager *AlertManager
+	// 告警状态的持久化存储，设置后每个统计周期保存一次告警状态，并在注册时恢复，默认为nil即不持久化
+	StateStore StateStore
+	// 告警状态文件的存放目录，未设置StateStore时，设置该值即启用基于文件的持久化存储
+	StateDir string
+	// 告警状态的有效期，注册时超过有效期的状态将被丢弃，默认1小时
+	StateMaxAge time.Duration
+	// 周期性的维护窗口，窗口内匹配的告警不发出通知
+	MaintenanceWindows []MaintenanceWindow
+	// 告警历史，记录告警、级别变化与恢复，可以被多个客户端共享以便统一查询，默认每个客户端保留最近1000条
+	AlertHistory *AlertHistory
+	// 客户端注册表，同一注册表内客户端名称不可重复，默认为DefaultRegistry
+	Registry *Registry
+	// 时钟，用于统计周期的计时与获取当前时间，默认为系统时钟
+	Clock Clock
 
 	// 自定义url或命名关于耗时达标，分布区间等属性。为了维持内部key的一致性，需要调用方法来设置这个属性
 	entryConfigMap map[string]EntryConfig
-	// 存储成功率告警以及恢复相关的数据
-	recentSuccessRateStatus map[string]*alertStatus
-	// 存储时延达标率以及恢复相关的数据
-	recentFastRateStatus map[string]*alertStatus
+	// 最新的告警阈值，注册之后告警阈值以此为准
+	thresholds *Thresholds
+	// 更新过配置、需要在下一个统计周期开始时应用的条目
+	pendingEntries map[string]bool
+	// 条目配置、告警阈值可能在运行时被更新，需要加锁
+	configLock *sync.RWMutex
+	// 当前统计周期生效的告警阈值，只在收集模块中读写，随数据流入统计与告警分析
+	cycleThresholds *Thresholds
+	// 按告警类型存储每个条目告警以及恢复相关的数据，例如成功率告警、时延达标率告警等
+	alertStatusMap map[AlertType]map[string]*alertStatus
 	// 上报通道，channel有利于解决资源竞争和缓存计算问题
 	taskChannel chan *taskQueue
 	// 收集累计每个条目的上报数据，用于统计分析
 	collectDataMap map[string]*reportData
 	// 分析通道
 	statisticsChannel chan reportData
+	// 告警分析通道，告警分析串行进行，保证同一条目的周期顺序并避免状态的并发读写
+	alertChannel chan OutPutData
+	// 通知队列，告警事件经由通知模块交给定制化的回调，回调阻塞时不影响告警分析与上报
+	eventQueue *eventQueue
+	// 每个条目的SLO跟踪数据，只在统计分析模块中读写
+	sloTrackerMap map[string]*sloTracker
+	// 每个条目最近若干个正常周期的调用次数，用于计算调用量基线，只在统计分析模块中读写
+	trafficBaselineMap map[string][]uint32
+	// 每个条目的异常检测器，只在统计分析模块中读写
+	anomalyDetectorMap map[string]*anomalyDetector
+	// 每个条目在各个降采样粒度上尚未结束的窗口，只在统计分析模块中读写
+	resolutionMap map[string][]*OutPutData
+	// 每个条目最近若干个周期的统计数据
+	outputHistory *outputHistory
+	// 各条目当前的告警状态
+	activeAlerts *activeAlerts
+	// 静默规则，可能被使用方和告警分析模块同时访问
+	silenceStore *silenceStore
+	// 客户端关闭时关闭该通道，通知各模块退出
+	done chan struct {}
+	// 保证重复关闭是安全的
+	closeOnce *sync.Once
 }
 
 // 状态码定制
 type CodeFeature struct {
-	Success bool			// 是否计为成功，默认为false
-	Name string				// 命名，用于出报表数据
+	Success bool `json:"success"`			// 是否计为成功，默认为false
+	Name string `json:"name"`				// 命名，用于出报表数据
 }
 
 // 使用上报必须先注册，得到一个唯一的客户端再进行上报
+// 兼容原有的注册方式，等同于New(c.Name, WithConfig(c))，名称已被注册时的处理同New
 func Register(c ReportClientConfig) ReportClient {
+	return New(c.Name, WithConfig(c))
+}
+
+// 校验并补全配置，启动客户端的各个模块
+// 名称已被注册时，exclusive为true则返回错误且不启动任何模块，否则替代原有的客户端并将其关闭
+func start(c ReportClientConfig, exclusive bool) (*ReportClientConfig, error) {
 	if c.Name == "" {
 		panic("必须为该上报类型注册一个名称")
 	}
@@ -102,23 +276,33 @@ func Register(c ReportClientConfig) ReportClient {
 	if c.StatisticalCycle <= 0 || c.StatisticalCycle > 300000 {
 		c.StatisticalCycle = 60000
 	}
-	if c.AlertForBadFastRateReachedTimes < 3 {
-		c.AlertForBadFastRateReachedTimes = 3
+	thresholds, err := normalizeThresholds(thresholdsOf(&c))
+	if err != nil {
+		panic(err.(*ConfigError).Message)
+	}
+	c.thresholds = &thresholds
+	c.cycleThresholds = c.thresholds
+	if c.TrafficBaselineCycles <= 0 {
+		c.TrafficBaselineCycles = 10
 	}
-	if c.AlertForGreatFastRateReachedTimes < 3 {
-		c.AlertForGreatFastRateReachedTimes = 3
+	if c.AlertForTrafficDropReachedTimes <= 0 {
+		c.AlertForTrafficDropReachedTimes = 3
 	}
-	if c.AlertForBadSuccessRateReachedTimes < 3 {
-		c.AlertForBadSuccessRateReachedTimes = 3
+	if c.WilsonConfidence < 0 || c.WilsonConfidence >= 1 {
+		panic("置信水平必须介于0和1之间")
 	}
-	if c.AlertForGreatSuccessRateReachedTimes < 3 {
-		c.AlertForGreatSuccessRateReachedTimes = 3
+	if c.AlertManager != nil {
+		c.AlertManager.validate()
 	}
-	if c.SuccessRate == 0 {
-		c.SuccessRate = 0.95
+	for _, resolution := range c.Resolutions {
+		if resolution <= time.Duration(c.StatisticalCycle) * time.Millisecond {
+			panic("降采样的粒度必须大于统计周期")
+		}
 	}
-	if c.FastRate == 0 {
-		c.FastRate = 0.8
+	for _, rollup := range c.Rollups {
+		if rollup.Name == "" {
+			panic("必须为汇总条目指定一个名称")
+		}
 	}
 	if c.ChannelCacheCount <= 0 {
 		c.ChannelCacheCount = 100
@@ -130,8 +314,35 @@ func Register(c ReportClientConfig) ReportClient {
 		c.DefaultFailDistributionFormat = "code[%code]"
 	}
 	c.entryConfigMap = map[string]EntryConfig {}
-	c.recentFastRateStatus = map[string]*alertStatus {}
-	c.recentSuccessRateStatus = map[string]*alertStatus {}
+	c.pendingEntries = map[string]bool {}
+	c.configLock = &sync.RWMutex {}
+	c.alertStatusMap = map[AlertType]map[string]*alertStatus {}
+	if c.StateStore == nil && c.StateDir != "" {
+		c.StateStore = NewFileStateStore(c.StateDir)
+	}
+	if c.StateMaxAge <= 0 {
+		c.StateMaxAge = time.Hour
+	}
+	if c.HistorySize == 0 {
+		c.HistorySize = 60
+	}
+	if c.FlapWindow <= 0 {
+		c.FlapWindow = time.Hour
+	}
+	if c.AlertHistory == nil {
+		c.AlertHistory = NewAlertHistory(0)
+	}
+	if c.Registry == nil {
+		c.Registry = DefaultRegistry
+	}
+	if c.Clock == nil {
+		c.Clock = systemClock {}
+	}
+	c.activeAlerts = &activeAlerts {alerts: map[string][]ActiveAlert {}}
+	// 恢复重启之前的告警状态
+	if c.StateStore != nil {
+		c.restoreState()
+	}
 	// 如果没有指定自定义code特征识别函数，且状态码映射为空，则启用默认的机制
 	if c.GetCodeFeature == nil && c.CodeFeatureMap == nil {
 		c.CodeFeatureMap = map[int]CodeFeature {
@@ -146,13 +357,47 @@ func Register(c ReportClientConfig) ReportClient {
 	// 建立一条统计分析的channel通道
 	client.statisticsChannel = make(chan reportData, c.ChannelCacheCount)
 	client.collectDataMap = map[string]*reportData {}
+	// 建立一条告警分析的channel通道
+	client.alertChannel = make(chan OutPutData, c.ChannelCacheCount)
+	// 建立通知队列
+	client.eventQueue = newEventQueue()
+	client.sloTrackerMap = map[string]*sloTracker {}
+	client.trafficBaselineMap = map[string][]uint32 {}
+	client.anomalyDetectorMap = map[string]*anomalyDetector {}
+	client.resolutionMap = map[string][]*OutPutData {}
+	client.outputHistory = &outputHistory {size: c.HistorySize, rings: map[string]*outputRing {}}
+	client.silenceStore = &silenceStore {}
+	client.done = make(chan struct {})
+	client.closeOnce = &sync.Once {}
+	// 名称重复时替代原有的客户端，原有的客户端关闭之后不再统计，避免存在无法通过注册表找到的客户端
+	if exclusive {
+		if !c.Registry.add(client) {
+			return nil, &ConfigError {Key: "name", Message: "客户端名称已被注册：" + c.Name}
+		}
+	} else if old := c.Registry.replace(client); old != nil {
+		old.Close()
+		os.Stderr.WriteString("客户端名称已被注册：" + c.Name + "，原有的客户端已关闭并由新的客户端替代\n")
+	}
 	// 启动收集模块
 	go client.collect()
 	// 启动定时器任务
 	go client.scheduleTask()
 	// 启动统计分析模块
 	go client.statistics()
-	return client
+	// 启动告警分析模块
+	go client.alert()
+	// 启动通知模块
+	go client.notify()
+	return client, nil
+}
+
+// 关闭客户端，当前周期尚未输出的数据将被丢弃，已保留的统计数据与告警历史仍可查询
+// 收集模块退出后依次关闭统计分析、告警分析的通道与通知队列，告警分析模块退出前保存一次告警状态，已进入通知队列的事件仍会送达
+func (c *ReportClientConfig) Close() {
+	c.closeOnce.Do(func() {
+		c.Registry.remove(c)
+		close(c.done)
+	})
 }
 
 // 默认输出回调函数，将直接打印到控制台
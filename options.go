@@ -0,0 +1,271 @@
+package monitor
+
+import (
+	"reflect"
+	"time"
+)
+
+// 客户端的配置项，通过New创建客户端时传入
+type Option func(c *ReportClientConfig)
+
+// 创建并注册一个客户端，未通过配置项设置的配置采用默认值，默认值同ReportClientConfig中的说明
+// 名称已被注册时新的客户端替代原有的客户端，原有的客户端将被关闭，当前周期尚未输出的数据随之丢弃
+func New(name string, opts ...Option) ReportClient {
+	c := ReportClientConfig {}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.Name = name
+	client, _ := start(c, false)
+	return client
+}
+
+// 以ReportClientConfig中的公开配置作为配置项，其中的Name将被忽略，用于兼容原有的注册方式
+// 只合并不为零值的配置，与其他配置项一样按顺序生效，不会清空之前的配置项，之后的配置项同样可以覆盖其中的配置
+func WithConfig(config ReportClientConfig) Option {
+	return func(c *ReportClientConfig) {
+		src, dst := reflect.ValueOf(config), reflect.ValueOf(c).Elem()
+		for i := 0; i < src.NumField(); i++ {
+			if field := src.Field(i); src.Type().Field(i).PkgPath == "" && !field.IsZero() {
+				dst.Field(i).Set(field)
+			}
+		}
+	}
+}
+
+// 客户端的标签
+func WithLabels(labels map[string]string) Option {
+	return func(c *ReportClientConfig) {
+		c.Labels = labels
+	}
+}
+
+// 默认高速访问时间，单位ms
+func WithDefaultFastTime(ms uint32) Option {
+	return func(c *ReportClientConfig) {
+		c.DefaultFastTime = ms
+	}
+}
+
+// 统计周期，精确到毫秒
+func WithStatisticalCycle(cycle time.Duration) Option {
+	return func(c *ReportClientConfig) {
+		c.StatisticalCycle = int(cycle / time.Millisecond)
+	}
+}
+
+// 告警阈值，包括成功率与高效访问率的阈值、告警与恢复的周期数、滑动窗口以及最少调用次数
+func WithThresholds(t Thresholds) Option {
+	return func(c *ReportClientConfig) {
+		c.SuccessRate = t.SuccessRate
+		c.FastRate = t.FastRate
+		c.WarningSuccessRate = t.WarningSuccessRate
+		c.WarningFastRate = t.WarningFastRate
+		c.AlertForBadSuccessRateReachedTimes = t.AlertForBadSuccessRateReachedTimes
+		c.AlertForBadFastRateReachedTimes = t.AlertForBadFastRateReachedTimes
+		c.AlertForGreatSuccessRateReachedTimes = t.AlertForGreatSuccessRateReachedTimes
+		c.AlertForGreatFastRateReachedTimes = t.AlertForGreatFastRateReachedTimes
+		c.AlertWindow = t.AlertWindow
+		c.MinRequestCount = t.MinRequestCount
+	}
+}
+
+// 以Wilson置信区间判定成功率与高效访问率是否达标的置信水平
+func WithWilsonConfidence(confidence float64) Option {
+	return func(c *ReportClientConfig) {
+		c.WilsonConfidence = confidence
+	}
+}
+
+// 启用无数据告警，连续times个统计周期没有上报数据时告警
+func WithNoDataAlert(times int) Option {
+	return func(c *ReportClientConfig) {
+		c.AlertForNoDataReachedTimes = times
+	}
+}
+
+// 条目在没有上报数据之后保留的统计周期数，含义同ReportClientConfig中的EntryRetentionCycles
+func WithEntryRetention(cycles int) Option {
+	return func(c *ReportClientConfig) {
+		c.EntryRetentionCycles = cycles
+	}
+}
+
+// 启用调用量下降告警，含义同ReportClientConfig中的TrafficDropRate、TrafficBaselineCycles与AlertForTrafficDropReachedTimes
+func WithTrafficDropAlert(rate float64, baselineCycles int, times int) Option {
+	return func(c *ReportClientConfig) {
+		c.TrafficDropRate = rate
+		c.TrafficBaselineCycles = baselineCycles
+		c.AlertForTrafficDropReachedTimes = times
+	}
+}
+
+// 汇总条目
+func WithRollups(rollups ...Rollup) Option {
+	return func(c *ReportClientConfig) {
+		c.Rollups = append(c.Rollups, rollups...)
+	}
+}
+
+// 上报管道的缓存个数
+func WithChannelCacheCount(count int) Option {
+	return func(c *ReportClientConfig) {
+		c.ChannelCacheCount = count
+	}
+}
+
+// 每个条目在内存中保留最近多少个周期的统计数据，小于0时不保留
+func WithHistorySize(size int) Option {
+	return func(c *ReportClientConfig) {
+		c.HistorySize = size
+	}
+}
+
+// 状态码的属性
+func WithCodeFeatures(codeFeatureMap map[int]CodeFeature) Option {
+	return func(c *ReportClientConfig) {
+		c.CodeFeatureMap = codeFeatureMap
+	}
+}
+
+// 自定义获取状态码属性的方式，优先于WithCodeFeatures
+func WithCodeFeatureFunc(getCodeFeature func(code int) (success bool, name string)) Option {
+	return func(c *ReportClientConfig) {
+		c.GetCodeFeature = getCodeFeature
+	}
+}
+
+// 失败分布中未命名状态码的格式
+func WithFailDistributionFormat(format string) Option {
+	return func(c *ReportClientConfig) {
+		c.DefaultFailDistributionFormat = format
+	}
+}
+
+// 统计数据的输出
+func WithOutput(outputCaller func(o *OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.OutputCaller = outputCaller
+	}
+}
+
+// 降采样的粒度以及降采样数据的输出，outputCaller为nil时输出到控制台
+func WithResolutions(outputCaller func(o *OutPutData), resolutions ...time.Duration) Option {
+	return func(c *ReportClientConfig) {
+		c.ResolutionOutputCaller = outputCaller
+		c.Resolutions = append(c.Resolutions, resolutions...)
+	}
+}
+
+// 告警通知
+func WithAlertCaller(alertCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.AlertCaller = alertCaller
+	}
+}
+
+// 恢复通知
+func WithRecoverCaller(recoverCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.RecoverCaller = recoverCaller
+	}
+}
+
+// 告警持续期间的重复通知间隔
+func WithRepeatInterval(interval time.Duration) Option {
+	return func(c *ReportClientConfig) {
+		c.RepeatInterval = interval
+	}
+}
+
+// 重复通知
+func WithRepeatCaller(repeatCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.RepeatCaller = repeatCaller
+	}
+}
+
+// 告警持续after之后升级通知，escalationCaller为nil时输出到控制台
+func WithEscalation(after time.Duration, escalationCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.EscalateAfter = after
+		c.EscalationCaller = escalationCaller
+	}
+}
+
+// 启用抖动检测，window内状态变化次数达到threshold时判定为抖动，flappingCaller为nil时输出到控制台
+func WithFlapping(threshold int, window time.Duration, flappingCaller func(clientName string, interfaceName string, alertType AlertType, recentOutputData []OutPutData)) Option {
+	return func(c *ReportClientConfig) {
+		c.FlapThreshold = threshold
+		c.FlapWindow = window
+		c.FlappingCaller = flappingCaller
+	}
+}
+
+// 告警事件的处理
+func WithEventCaller(eventCaller func(e *AlertEvent)) Option {
+	return func(c *ReportClientConfig) {
+		c.EventCaller = eventCaller
+	}
+}
+
+// 某个告警级别的告警事件处理，可以多次使用以设置不同的级别
+func WithSeverityCaller(severity Severity, eventCaller func(e *AlertEvent)) Option {
+	return func(c *ReportClientConfig) {
+		if c.SeverityCallers == nil {
+			c.SeverityCallers = map[Severity]func(e *AlertEvent) {}
+		}
+		c.SeverityCallers[severity] = eventCaller
+	}
+}
+
+// 告警管理器
+func WithAlertManager(alertManager *AlertManager) Option {
+	return func(c *ReportClientConfig) {
+		c.AlertManager = alertManager
+	}
+}
+
+// 维护窗口
+func WithMaintenanceWindows(windows ...MaintenanceWindow) Option {
+	return func(c *ReportClientConfig) {
+		c.MaintenanceWindows = append(c.MaintenanceWindows, windows...)
+	}
+}
+
+// 告警状态的持久化存储以及状态的有效期，maxAge为0时默认1小时
+func WithStateStore(store StateStore, maxAge time.Duration) Option {
+	return func(c *ReportClientConfig) {
+		c.StateStore = store
+		c.StateMaxAge = maxAge
+	}
+}
+
+// 将告警状态保存到dir目录下的文件中，状态的有效期同WithStateStore
+func WithStateDir(dir string, maxAge time.Duration) Option {
+	return func(c *ReportClientConfig) {
+		c.StateDir = dir
+		c.StateMaxAge = maxAge
+	}
+}
+
+// 告警历史
+func WithAlertHistory(alertHistory *AlertHistory) Option {
+	return func(c *ReportClientConfig) {
+		c.AlertHistory = alertHistory
+	}
+}
+
+// 客户端注册表
+func WithRegistry(registry *Registry) Option {
+	return func(c *ReportClientConfig) {
+		c.Registry = registry
+	}
+}
+
+// 时钟
+func WithClock(clock Clock) Option {
+	return func(c *ReportClientConfig) {
+		c.Clock = clock
+	}
+}
@@ -0,0 +1,117 @@
+package monitor
+
+import "sync"
+
+// 客户端注册表，按名称跟踪已注册的客户端，同一注册表内客户端名称不可重复，重复注册时新的客户端替代原有的客户端，客户端关闭后自动注销
+// 状态页与查询接口通过注册表列出客户端
+type Registry struct {
+	// 注册、注销与查询可能同时发生，需要加锁
+	lock sync.Mutex
+	// 按注册顺序排列
+	clients []*ReportClientConfig
+}
+
+// 默认的注册表，未指定Registry的客户端均注册到这里
+var DefaultRegistry = NewRegistry()
+
+// 创建一个注册表
+func NewRegistry() *Registry {
+	return &Registry {}
+}
+
+// 按名称查找客户端
+func (r *Registry) Lookup(name string) (ReportClient, bool) {
+	if c := r.lookup(name); c != nil {
+		return c, true
+	}
+	return nil, false
+}
+
+// 全部已注册的客户端，按注册顺序排列
+func (r *Registry) All() []ReportClient {
+	clients := r.registered()
+	result := make([]ReportClient, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, c)
+	}
+	return result
+}
+
+// 在默认注册表中按名称查找客户端
+func Lookup(name string) (ReportClient, bool) {
+	return DefaultRegistry.Lookup(name)
+}
+
+// 默认注册表中全部已注册的客户端
+func All() []ReportClient {
+	return DefaultRegistry.All()
+}
+
+// 在注册表中注册客户端，名称已被注册时返回ConfigError且不创建客户端，不会替代原有的客户端
+// 配置中的Registry将被忽略，配置不合法时同Register一样panic
+func (r *Registry) Register(c ReportClientConfig) (ReportClient, error) {
+	c.Registry = r
+	client, err := start(c, true)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// 添加客户端，名称已被注册时返回false
+func (r *Registry) add(c *ReportClientConfig) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, client := range r.clients {
+		if client.Name == c.Name {
+			return false
+		}
+	}
+	r.clients = append(r.clients, c)
+	return true
+}
+
+// 添加客户端，名称已被注册时在原有的位置替代原有的客户端并将其返回
+func (r *Registry) replace(c *ReportClientConfig) *ReportClientConfig {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, client := range r.clients {
+		if client.Name == c.Name {
+			r.clients[i] = c
+			return client
+		}
+	}
+	r.clients = append(r.clients, c)
+	return nil
+}
+
+// 注销客户端
+func (r *Registry) remove(c *ReportClientConfig) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, client := range r.clients {
+		if client == c {
+			r.clients = append(r.clients[:i], r.clients[i + 1:]...)
+			return
+		}
+	}
+}
+
+// 全部已注册的客户端
+func (r *Registry) registered() []*ReportClientConfig {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]*ReportClientConfig {}, r.clients...)
+}
+
+// 按名称查找已注册的客户端，不存在时返回nil
+func (r *Registry) lookup(name string) *ReportClientConfig {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, c := range r.clients {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 可在运行时更新的客户端告警阈值，各项含义同ReportClientConfig中的同名配置，为0时同样采用注册时的默认值
+type Thresholds struct {
+	SuccessRate float64 `json:"successRate"`
+	FastRate float64 `json:"fastRate"`
+	WarningSuccessRate float64 `json:"warningSuccessRate"`
+	WarningFastRate float64 `json:"warningFastRate"`
+	AlertForBadSuccessRateReachedTimes int `json:"alertForBadSuccessRateReachedTimes"`
+	AlertForBadFastRateReachedTimes int `json:"alertForBadFastRateReachedTimes"`
+	AlertForGreatSuccessRateReachedTimes int `json:"alertForGreatSuccessRateReachedTimes"`
+	AlertForGreatFastRateReachedTimes int `json:"alertForGreatFastRateReachedTimes"`
+	AlertWindow int `json:"alertWindow"`
+	MinRequestCount int `json:"minRequestCount"`
+}
+
+// 告警阈值的更新，只更新不为nil的项，其余保持当前的值，各项含义同Thresholds
+type ThresholdsUpdate struct {
+	SuccessRate *float64 `json:"successRate,omitempty"`
+	FastRate *float64 `json:"fastRate,omitempty"`
+	WarningSuccessRate *float64 `json:"warningSuccessRate,omitempty"`
+	WarningFastRate *float64 `json:"warningFastRate,omitempty"`
+	AlertForBadSuccessRateReachedTimes *int `json:"alertForBadSuccessRateReachedTimes,omitempty"`
+	AlertForBadFastRateReachedTimes *int `json:"alertForBadFastRateReachedTimes,omitempty"`
+	AlertForGreatSuccessRateReachedTimes *int `json:"alertForGreatSuccessRateReachedTimes,omitempty"`
+	AlertForGreatFastRateReachedTimes *int `json:"alertForGreatFastRateReachedTimes,omitempty"`
+	AlertWindow *int `json:"alertWindow,omitempty"`
+	MinRequestCount *int `json:"minRequestCount,omitempty"`
+}
+
+// 一次配置更新
+type ConfigUpdate struct {
+	// 告警阈值的更新，为nil时不更新
+	Thresholds *ThresholdsUpdate `json:"thresholds,omitempty"`
+	// 新增或替换的条目配置，以条目名称为键
+	Entries map[string]EntryConfig `json:"entries,omitempty"`
+	// 移除配置的条目，移除之后采用默认的配置，同时出现在Entries中时以Entries为准
+	RemoveEntries []string `json:"removeEntries,omitempty"`
+}
+
+// 一个发生变化的配置项
+type ConfigChange struct {
+	// 客户端命名
+	ClientName string `json:"clientName"`
+	// 条目名称，为空表示客户端的告警阈值
+	InterfaceName string `json:"interfaceName,omitempty"`
+	// 配置项，与json中的名称一致
+	Key string `json:"key"`
+	// 原来的值
+	Old interface {} `json:"old"`
+	// 新的值
+	New interface {} `json:"new"`
+}
+
+// 客户端配置中的告警阈值
+func thresholdsOf(c *ReportClientConfig) Thresholds {
+	return Thresholds {
+		SuccessRate: c.SuccessRate,
+		FastRate: c.FastRate,
+		WarningSuccessRate: c.WarningSuccessRate,
+		WarningFastRate: c.WarningFastRate,
+		AlertForBadSuccessRateReachedTimes: c.AlertForBadSuccessRateReachedTimes,
+		AlertForBadFastRateReachedTimes: c.AlertForBadFastRateReachedTimes,
+		AlertForGreatSuccessRateReachedTimes: c.AlertForGreatSuccessRateReachedTimes,
+		AlertForGreatFastRateReachedTimes: c.AlertForGreatFastRateReachedTimes,
+		AlertWindow: c.AlertWindow,
+		MinRequestCount: c.MinRequestCount,
+	}
+}
+
+// 更新全部告警阈值的ThresholdsUpdate
+func thresholdsUpdateOf(t Thresholds) *ThresholdsUpdate {
+	return &ThresholdsUpdate {
+		SuccessRate: &t.SuccessRate,
+		FastRate: &t.FastRate,
+		WarningSuccessRate: &t.WarningSuccessRate,
+		WarningFastRate: &t.WarningFastRate,
+		AlertForBadSuccessRateReachedTimes: &t.AlertForBadSuccessRateReachedTimes,
+		AlertForBadFastRateReachedTimes: &t.AlertForBadFastRateReachedTimes,
+		AlertForGreatSuccessRateReachedTimes: &t.AlertForGreatSuccessRateReachedTimes,
+		AlertForGreatFastRateReachedTimes: &t.AlertForGreatFastRateReachedTimes,
+		AlertWindow: &t.AlertWindow,
+		MinRequestCount: &t.MinRequestCount,
+	}
+}
+
+// 将更新合并到告警阈值上
+func (u *ThresholdsUpdate) merge(t Thresholds) Thresholds {
+	if u.SuccessRate != nil {
+		t.SuccessRate = *u.SuccessRate
+	}
+	if u.FastRate != nil {
+		t.FastRate = *u.FastRate
+	}
+	if u.WarningSuccessRate != nil {
+		t.WarningSuccessRate = *u.WarningSuccessRate
+	}
+	if u.WarningFastRate != nil {
+		t.WarningFastRate = *u.WarningFastRate
+	}
+	if u.AlertForBadSuccessRateReachedTimes != nil {
+		t.AlertForBadSuccessRateReachedTimes = *u.AlertForBadSuccessRateReachedTimes
+	}
+	if u.AlertForBadFastRateReachedTimes != nil {
+		t.AlertForBadFastRateReachedTimes = *u.AlertForBadFastRateReachedTimes
+	}
+	if u.AlertForGreatSuccessRateReachedTimes != nil {
+		t.AlertForGreatSuccessRateReachedTimes = *u.AlertForGreatSuccessRateReachedTimes
+	}
+	if u.AlertForGreatFastRateReachedTimes != nil {
+		t.AlertForGreatFastRateReachedTimes = *u.AlertForGreatFastRateReachedTimes
+	}
+	if u.AlertWindow != nil {
+		t.AlertWindow = *u.AlertWindow
+	}
+	if u.MinRequestCount != nil {
+		t.MinRequestCount = *u.MinRequestCount
+	}
+	return t
+}
+
+// 规范化告警阈值，不合法时返回ConfigError
+func normalizeThresholds(t Thresholds) (Thresholds, error) {
+	if err := validateRates(t.SuccessRate, t.FastRate, t.WarningSuccessRate, t.WarningFastRate); err != nil {
+		return t, err
+	}
+	if t.AlertForBadFastRateReachedTimes < 3 {
+		t.AlertForBadFastRateReachedTimes = 3
+	}
+	if t.AlertForGreatFastRateReachedTimes < 3 {
+		t.AlertForGreatFastRateReachedTimes = 3
+	}
+	if t.AlertForBadSuccessRateReachedTimes < 3 {
+		t.AlertForBadSuccessRateReachedTimes = 3
+	}
+	if t.AlertForGreatSuccessRateReachedTimes < 3 {
+		t.AlertForGreatSuccessRateReachedTimes = 3
+	}
+	if t.SuccessRate == 0 {
+		t.SuccessRate = 0.95
+	}
+	if t.FastRate == 0 {
+		t.FastRate = 0.8
+	}
+	return t, nil
+}
+
+// 最新的告警阈值
+func (c *ReportClientConfig) currentThresholds() *Thresholds {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+	return c.thresholds
+}
+
+// 在运行时更新告警阈值与条目配置，返回发生变化的配置项
+// 新的配置在下一个统计周期开始时生效，当前周期的数据仍按原配置统计与分析，时延分布区间发生变化的条目将重建分布统计
+// 校验沿用注册与添加条目配置时的规则，不合法时返回ConfigError且不更新任何配置，Key为出错的配置项，条目配置的前缀为entries["条目名称"]
+func (c *ReportClientConfig) UpdateConfig(u ConfigUpdate) ([]ConfigChange, error) {
+	entries, err := validateUpdate(u)
+	if err != nil {
+		return nil, err
+	}
+	return c.applyUpdate(u, entries), nil
+}
+
+// 校验配置更新，返回规范化之后的条目配置
+func validateUpdate(u ConfigUpdate) (map[string]EntryConfig, error) {
+	entries := map[string]EntryConfig {}
+	for _, name := range sortedEntryNames(u.Entries) {
+		entryConfig, err := normalizeEntryConfig(u.Entries[name])
+		if err != nil {
+			e := err.(*ConfigError)
+			return nil, &ConfigError {Key: joinConfigKey("entries[" + strconv.Quote(name) + "]", e.Key), Message: e.Message}
+		}
+		entries[name] = entryConfig
+	}
+	if u.Thresholds != nil {
+		// 未更新的项沿用当前的值，当前的值已经通过校验，只需校验更新的项
+		if _, err := normalizeThresholds(u.Thresholds.merge(Thresholds {})); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// 应用已经通过校验的配置更新，返回发生变化的配置项
+func (c *ReportClientConfig) applyUpdate(u ConfigUpdate, entries map[string]EntryConfig) []ConfigChange {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	changes := []ConfigChange {}
+	if u.Thresholds != nil {
+		// 在当前的告警阈值上合并，加锁期间进行以免并发的更新相互覆盖
+		t, _ := normalizeThresholds(u.Thresholds.merge(*c.thresholds))
+		thresholds := &t
+		changes = append(changes, configChanges(c.Name, "", c.thresholds, thresholds)...)
+		c.thresholds = thresholds
+	}
+	for _, name := range sortedEntryNames(entries) {
+		// 新增的条目与未设置任何配置项时添加的条目配置对比，只列出实际设置的配置项
+		old, _ := normalizeEntryConfig(EntryConfig {})
+		if entryConfig, ok := c.entryConfigMap[name]; ok {
+			old = entryConfig
+		}
+		changes = append(changes, configChanges(c.Name, name, old, entries[name])...)
+		c.entryConfigMap[name] = entries[name]
+		c.pendingEntries[name] = true
+	}
+	removeEntries := append([]string {}, u.RemoveEntries...)
+	sort.Strings(removeEntries)
+	for _, name := range removeEntries {
+		entryConfig, ok := c.entryConfigMap[name]
+		if _, replaced := entries[name]; !ok || replaced {
+			continue
+		}
+		// 移除之后条目采用默认的配置
+		changes = append(changes, configChanges(c.Name, name, entryConfig, *defaultEntryConfig)...)
+		delete(c.entryConfigMap, name)
+		c.pendingEntries[name] = true
+	}
+	return changes
+}
+
+// 按名称排列的条目
+func sortedEntryNames(entries map[string]EntryConfig) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 应用上一个统计周期内更新的配置，只在收集模块清理数据之后调用
+func (c *ReportClientConfig) applyConfig() {
+	c.configLock.Lock()
+	c.cycleThresholds = c.thresholds
+	pendingEntries := c.pendingEntries
+	c.pendingEntries = map[string]bool {}
+	c.configLock.Unlock()
+	for name := range pendingEntries {
+		curCollectData, ok := c.collectDataMap[name]
+		if !ok {
+			continue
+		}
+		config := c.getEntryConfig(name)
+		// 时延分布区间发生变化时，按新的区间重建分布统计
+		if config.TimeConsumingDistributionSplit != curCollectData.Config.TimeConsumingDistributionSplit ||
+			config.TimeConsumingDistributionMax != curCollectData.Config.TimeConsumingDistributionMax ||
+			config.TimeConsumingDistributionMin != curCollectData.Config.TimeConsumingDistributionMin {
+			curCollectData.TimeConsumingDistribution = make([]uint32, config.TimeConsumingDistributionSplit)
+		}
+		curCollectData.Config = config
+	}
+}
+
+// 对比两份配置，以json中的名称列出发生变化的配置项
+func configChanges(clientName string, interfaceName string, old interface {}, new interface {}) []ConfigChange {
+	oldMap, newMap := configMap(old), configMap(new)
+	keys := []string {}
+	for key := range newMap {
+		keys = append(keys, key)
+	}
+	for key := range oldMap {
+		if _, ok := newMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	changes := []ConfigChange {}
+	for _, key := range keys {
+		if !reflect.DeepEqual(oldMap[key], newMap[key]) {
+			changes = append(changes, ConfigChange {
+				ClientName: clientName,
+				InterfaceName: interfaceName,
+				Key: key,
+				Old: oldMap[key],
+				New: newMap[key],
+			})
+		}
+	}
+	return changes
+}
+
+// 配置转为以json名称为键的map
+func configMap(v interface {}) map[string]interface {} {
+	m := map[string]interface {} {}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &m)
+	return m
+}
+
+// 重新读取配置文件，更新其中客户端的告警阈值与条目配置，返回发生变化的配置项
+// 配置文件中的客户端必须已经注册，只有告警阈值与条目配置支持重新加载，其他配置项的修改需要重新注册客户端才能生效
+// 全部客户端的配置都通过校验之后才会更新，任何一处有误时不更新任何配置；从配置文件中删除的条目恢复为默认的配置
+func (l *ConfigLoader) Reload(path string) ([]ConfigChange, error) {
+	data, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configs, entries, err := l.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*ReportClientConfig, len(configs))
+	for i := range configs {
+		c := l.registry().lookup(configs[i].Name)
+		if c == nil {
+			return nil, &ConfigError {Key: fmt.Sprintf("clients[%d].name", i), Message: "客户端未注册：" + configs[i].Name}
+		}
+		clients[i] = c
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	updates := make([]ConfigUpdate, len(clients))
+	validEntries := make([]map[string]EntryConfig, len(clients))
+	for i := range clients {
+		// 配置文件描述完整的告警阈值，未配置的项采用默认值
+		updates[i] = ConfigUpdate {Thresholds: thresholdsUpdateOf(thresholdsOf(&configs[i])), Entries: entries[i]}
+		for _, name := range l.entryNames[configs[i].Name] {
+			if _, ok := entries[i][name]; !ok {
+				updates[i].RemoveEntries = append(updates[i].RemoveEntries, name)
+			}
+		}
+		if validEntries[i], err = validateUpdate(updates[i]); err != nil {
+			e := err.(*ConfigError)
+			return nil, &ConfigError {Key: joinConfigKey(fmt.Sprintf("clients[%d]", i), e.Key), Message: e.Message}
+		}
+	}
+	changes := []ConfigChange {}
+	for i, c := range clients {
+		changes = append(changes, c.applyUpdate(updates[i], validEntries[i])...)
+		l.recordEntries(configs[i].Name, entries[i])
+	}
+	return changes, nil
+}
+
+// 记录客户端从配置文件中加载的条目，重新加载时据此找出被删除的条目，调用时需持有lock
+func (l *ConfigLoader) recordEntries(clientName string, entries map[string]EntryConfig) {
+	if l.entryNames == nil {
+		l.entryNames = map[string][]string {}
+	}
+	l.entryNames[clientName] = sortedEntryNames(entries)
+}
+
+// 每隔interval检查一次配置文件的修改时间，修改之后重新加载，结果交给ReloadCaller，返回停止检查的函数
+func (l *ConfigLoader) Watch(path string, interval time.Duration) (stop func()) {
+	done := make(chan struct {})
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+			// 文件可能正在被替换，读取不到时等待下一次检查
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			changes, err := l.Reload(path)
+			if l.ReloadCaller != nil {
+				l.ReloadCaller(changes, err)
+			} else {
+				defaultReloadCaller(changes, err)
+			}
+		}
+	}()
+	once := &sync.Once {}
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// 默认的重新加载结果处理，发生变化的配置项输出到控制台，错误输出到标准错误
+func defaultReloadCaller(changes []ConfigChange, err error) {
+	if err != nil {
+		os.Stderr.WriteString("重新加载配置失败：" + err.Error() + "\n")
+		return
+	}
+	for _, change := range changes {
+		b, _ := json.Marshal(change)
+		os.Stdout.Write(b)
+		os.Stdout.WriteString("\n")
+	}
+}
@@ -14,9 +14,8 @@ type reportServer struct {
 	Code int
 }
 
-// 清理任务携带的数据
+// 清理任务携带的数据，一次清理任务将扫描所有条目
 type clearData struct {
-	Name string
 	Time time.Time
 }
 
@@ -35,12 +34,16 @@ func (c *ReportClientConfig) Report(name string, ms uint32, code int) {
 	if c.taskChannel == nil {
 		panic("请首先注册该上报类型")
 	}
-	c.taskChannel <- &taskQueue {
+	// 客户端关闭之后的上报直接丢弃
+	select {
+	case c.taskChannel <- &taskQueue {
 		taskType: SERVER,
 		data: reportServer {
 			Code: 	code,
 			Ms: 	ms,
 			Name:   name,
 		},
+	}:
+	case <-c.done:
 	}
 }
\ No newline at end of file
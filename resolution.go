@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"time"
+)
+
+// 将统计周期的数据合并到各个粗粒度窗口中，某个窗口之后的周期到来时输出该窗口的数据，只在统计分析模块中调用
+// 窗口按UTC时间对齐，例如1小时窗口为整点到下一个整点，周期按其开始时间归入窗口
+func (c *ReportClientConfig) downsample(outputData *OutPutData) {
+	windows, ok := c.resolutionMap[outputData.InterfaceName]
+	if !ok {
+		windows = make([]*OutPutData, len(c.Resolutions))
+		c.resolutionMap[outputData.InterfaceName] = windows
+	}
+	cycleStart := outputData.Timestamp.Add(-time.Duration(c.StatisticalCycle) * time.Millisecond)
+	for i, resolution := range c.Resolutions {
+		windowStart := cycleStart.Truncate(resolution)
+		window := windows[i]
+		if window != nil && !window.Timestamp.Equal(windowStart) {
+			c.outputResolution(window)
+			window = nil
+		}
+		// 没有调用的周期只用于推动窗口的输出
+		if outputData.Count > 0 {
+			if window == nil {
+				window = &OutPutData {
+					Timestamp: windowStart,
+					ClientName: outputData.ClientName,
+					InterfaceName: outputData.InterfaceName,
+					Resolution: formatWindow(resolution),
+					FailDistribution: map[string]uint32 {},
+					TimeConsumingDistribution: map[string]uint32 {},
+				}
+			}
+			window.merge(outputData)
+		}
+		windows[i] = window
+	}
+}
+
+// 合并一个周期的数据，比例与平均耗时按合并之后的总数重新计算
+func (o *OutPutData) merge(d *OutPutData) {
+	if d.SuccessCount > 0 {
+		if o.MinMs == 0 || d.MinMs < o.MinMs {
+			o.MinMs = d.MinMs
+		}
+		if d.MaxMs > o.MaxMs {
+			o.MaxMs = d.MaxMs
+		}
+	}
+	o.Count += d.Count
+	o.SuccessCount += d.SuccessCount
+	o.FailCount += d.FailCount
+	o.FastCount += d.FastCount
+	o.successMsCount += d.successMsCount
+	o.SuccessRate = float64(o.SuccessCount) / float64(o.Count)
+	o.FastRate = float64(o.FastCount) / float64(o.Count)
+	o.SuccessMsAver = uint32(float64(o.successMsCount) / float64(o.Count))
+	for k, v := range d.FailDistribution {
+		o.FailDistribution[k] += v
+	}
+	for k, v := range d.TimeConsumingDistribution {
+		o.TimeConsumingDistribution[k] += v
+	}
+}
+
+// 输出粗粒度窗口的数据
+func (c *ReportClientConfig) outputResolution(o *OutPutData) {
+	if c.ResolutionOutputCaller != nil {
+		go c.ResolutionOutputCaller(o)
+	} else {
+		defaultOutputCaller(o)
+	}
+}
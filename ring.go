@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 每个条目最近若干个周期的统计数据，统计分析模块的写入与使用方的查询可能同时发生，需要加锁
+type outputHistory struct {
+	lock sync.Mutex
+	// 每个条目保留的周期数
+	size int
+	rings map[string]*outputRing
+}
+
+// 环形缓冲区，写满之后覆盖最早的数据
+type outputRing struct {
+	data []OutPutData
+	// 下一个写入的位置
+	next int
+}
+
+// 记录一个周期的统计数据
+func (h *outputHistory) add(o OutPutData) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[o.InterfaceName]
+	if !ok {
+		ring = &outputRing {data: make([]OutPutData, 0, h.size)}
+		h.rings[o.InterfaceName] = ring
+	}
+	if len(ring.data) < h.size {
+		ring.data = append(ring.data, o)
+	} else {
+		ring.data[ring.next] = o
+	}
+	ring.next = (ring.next + 1) % h.size
+}
+
+// 移除条目的全部数据
+func (h *outputHistory) remove(entryName string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.rings, entryName)
+}
+
+// 按时间先后遍历缓冲区中的数据
+func (r *outputRing) each(f func(o *OutPutData)) {
+	start := 0
+	if len(r.data) == cap(r.data) {
+		start = r.next
+	}
+	for i := 0; i < len(r.data); i++ {
+		f(&r.data[(start + i) % len(r.data)])
+	}
+}
+
+// 查询条目在[from, to)时间范围内的统计数据，按时间先后排列，from或to为零值时表示不限制
+func (c *ReportClientConfig) History(entryName string, from time.Time, to time.Time) []OutPutData {
+	h := c.outputHistory
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	history := []OutPutData {}
+	ring, ok := h.rings[entryName]
+	if !ok {
+		return history
+	}
+	ring.each(func(o *OutPutData) {
+		if (from.IsZero() || !o.Timestamp.Before(from)) && (to.IsZero() || o.Timestamp.Before(to)) {
+			history = append(history, *o)
+		}
+	})
+	return history
+}
+
+// 条目最近一个周期的统计数据，条目还没有输出过数据时返回false
+func (c *ReportClientConfig) Latest(entryName string) (OutPutData, bool) {
+	h := c.outputHistory
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[entryName]
+	if !ok {
+		return OutPutData {}, false
+	}
+	return ring.data[(ring.next + len(ring.data) - 1) % len(ring.data)], true
+}
+
+// 保留了统计数据的条目，按名称排列
+func (c *ReportClientConfig) Entries() []string {
+	h := c.outputHistory
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	entries := make([]string, 0, len(h.rings))
+	for name := range h.rings {
+		entries = append(entries, name)
+	}
+	sort.Strings(entries)
+	return entries
+}
@@ -0,0 +1,76 @@
+package monitor
+
+import (
+	"time"
+)
+
+// 汇总条目，将匹配的多个条目的上报数据汇总为一个条目，与普通条目一样输出统计数据并参与告警
+type Rollup struct {
+	// 汇总条目的名称，不应与实际上报的条目重名，可以通过AddEntryConfig为其定制耗时达标、告警阈值等配置
+	Name string `json:"name"`
+	// 匹配的条目，支持*和?通配符，例如"* - /api/v2/*"，为空表示该客户端的全部条目，即客户端的总计
+	EntryPatterns []string `json:"entryPatterns"`
+}
+
+// 条目是否属于该汇总条目
+func (r *Rollup) match(entryName string) bool {
+	if len(r.EntryPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range r.EntryPatterns {
+		if matchPattern(pattern, entryName) {
+			return true
+		}
+	}
+	return false
+}
+
+// 计算各个汇总条目本周期的数据，只在收集模块中调用，还没有任何匹配条目的汇总条目不输出
+func (c *ReportClientConfig) rollupData(t time.Time) []reportData {
+	rollupDataList := make([]reportData, 0, len(c.Rollups))
+	for i := range c.Rollups {
+		rollup := &c.Rollups[i]
+		config := c.getEntryConfig(rollup.Name)
+		rollupData := reportData {
+			Name: rollup.Name,
+			FailDistribution: map[int]uint32 {},
+			TimeConsumingDistribution: make([]uint32, config.TimeConsumingDistributionSplit),
+			Config: config,
+			thresholds: c.cycleThresholds,
+			Time: t,
+		}
+		matched := false
+		for name, curCollectData := range c.collectDataMap {
+			if rollup.match(name) {
+				matched = true
+				rollupData.merge(curCollectData)
+			}
+		}
+		if matched {
+			rollupDataList = append(rollupDataList, rollupData)
+		}
+	}
+	return rollupDataList
+}
+
+// 累加另一个条目的数据，时延分布区间不同时，按原区间的起点归入本条目的区间
+func (d *reportData) merge(o *reportData) {
+	if o.SuccessCount > 0 {
+		if d.MinMs == 0 || o.MinMs < d.MinMs {
+			d.MinMs = o.MinMs
+		}
+		if o.MaxMs > d.MaxMs {
+			d.MaxMs = o.MaxMs
+		}
+	}
+	d.SuccessMsCount += o.SuccessMsCount
+	d.SuccessCount += o.SuccessCount
+	d.FastCount += o.FastCount
+	d.FailCount += o.FailCount
+	for code, count := range o.FailDistribution {
+		d.FailDistribution[code] += count
+	}
+	for i, count := range o.TimeConsumingDistribution {
+		d.TimeConsumingDistribution[d.Config.distributionIndex(o.Config.distributionStart(i))] += count
+	}
+}
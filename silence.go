@@ -0,0 +1,189 @@
+package monitor
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 静默规则，在生效期间匹配的告警不会发出通知，但告警状态照常记录
+type Silence struct {
+	// 静默ID，添加时自动生成
+	ID string `json:"id"`
+	// 匹配的条目，支持*和?通配符，为空表示该客户端的全部条目
+	EntryPattern string `json:"entryPattern"`
+	// 匹配的告警类型，为空表示全部告警类型
+	AlertTypes []AlertType `json:"alertTypes"`
+	// 开始时间，为零值时表示立即开始
+	StartsAt time.Time `json:"startsAt"`
+	// 结束时间
+	EndsAt time.Time `json:"endsAt"`
+	// 创建人
+	CreatedBy string `json:"createdBy"`
+	// 备注，例如静默的原因
+	Comment string `json:"comment"`
+}
+
+// 周期性的维护窗口，例如每周二凌晨2点到4点，在窗口内匹配的告警不会发出通知
+type MaintenanceWindow struct {
+	// 匹配的条目，支持*和?通配符，为空表示该客户端的全部条目
+	EntryPattern string
+	// 匹配的告警类型，为空表示全部告警类型
+	AlertTypes []AlertType
+	// 每周的哪几天开始维护，为空表示每天
+	Weekdays []time.Weekday
+	// 开始时刻，以相对当天零点的偏移表示，例如2 * time.Hour表示凌晨2点
+	Start time.Duration
+	// 持续时间，允许跨过零点
+	Duration time.Duration
+	// 时区，默认为本地时区
+	Location *time.Location
+	// 备注
+	Comment string
+}
+
+// 静默规则的存储，使用方添加删除与告警分析模块的读取可能同时发生，需要加锁
+type silenceStore struct {
+	lock sync.Mutex
+	// 静默ID的自增序号
+	seq uint64
+	silences []Silence
+}
+
+// 添加静默规则，返回静默ID
+func (c *ReportClientConfig) Silence(s Silence) string {
+	if !s.EndsAt.After(s.StartsAt) {
+		panic("静默的结束时间必须晚于开始时间")
+	}
+	store := c.silenceStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.seq++
+	s.ID = strconv.FormatUint(store.seq, 10)
+	store.silences = append(store.silences, s)
+	return s.ID
+}
+
+// 删除静默规则，返回静默是否存在
+func (c *ReportClientConfig) Unsilence(id string) bool {
+	store := c.silenceStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for i, s := range store.silences {
+		if s.ID == id {
+			store.silences = append(store.silences[:i], store.silences[i + 1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 列出尚未结束的静默规则
+func (c *ReportClientConfig) Silences() []Silence {
+	store := c.silenceStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := c.Clock.Now()
+	silences := make([]Silence, 0, len(store.silences))
+	// 顺便清理已经结束的静默
+	active := store.silences[:0]
+	for _, s := range store.silences {
+		if s.EndsAt.After(now) {
+			active = append(active, s)
+			silences = append(silences, s)
+		}
+	}
+	store.silences = active
+	return silences
+}
+
+// 判断条目的某种告警在指定时间是否被静默
+func (c *ReportClientConfig) silenced(entryName string, alertType AlertType, t time.Time) bool {
+	for _, w := range c.MaintenanceWindows {
+		if w.active(t) && matchAlert(w.EntryPattern, w.AlertTypes, entryName, alertType) {
+			return true
+		}
+	}
+	store := c.silenceStore
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, s := range store.silences {
+		if !t.Before(s.StartsAt) && t.Before(s.EndsAt) && matchAlert(s.EntryPattern, s.AlertTypes, entryName, alertType) {
+			return true
+		}
+	}
+	return false
+}
+
+// 维护窗口在指定时间是否生效
+func (w *MaintenanceWindow) active(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	// 跨过零点的窗口可能是前几天开始的
+	for day := today; !day.Add(w.Start + w.Duration).Before(t); day = day.AddDate(0, 0, -1) {
+		start := day.Add(w.Start)
+		if !t.Before(start) && t.Before(start.Add(w.Duration)) && w.matchWeekday(day.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否在指定的星期开始维护
+func (w *MaintenanceWindow) matchWeekday(weekday time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断告警是否匹配条目通配符和告警类型，通配符和告警类型为空时表示全部匹配
+func matchAlert(entryPattern string, alertTypes []AlertType, entryName string, alertType AlertType) bool {
+	if entryPattern != "" && !matchPattern(entryPattern, entryName) {
+		return false
+	}
+	if len(alertTypes) == 0 {
+		return true
+	}
+	for _, t := range alertTypes {
+		if t == alertType {
+			return true
+		}
+	}
+	return false
+}
+
+// 通配符匹配，*匹配任意长度的任意字符（包括/），?匹配单个字符
+func matchPattern(pattern string, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	// 回溯到最近一个*的位置
+	star, match := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		if i < len(p) && (p[i] == '?' || p[i] == n[j]) {
+			i++
+			j++
+		} else if i < len(p) && p[i] == '*' {
+			star, match = i, j
+			i++
+		} else if star >= 0 {
+			match++
+			i, j = star + 1, match
+		} else {
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
@@ -0,0 +1,267 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 条目的SLO定义，可用性目标与时延目标可以只设置其一，为0表示不跟踪
+type SLOConfig struct {
+	// 可用性目标，即成功数/调用总数应当达到的比例，例如0.999
+	Availability float64 `json:"availability"`
+	// 时延目标，即成功调用中耗时达标（不超过FastLessThan）的比例，例如0.99
+	Latency float64 `json:"latency"`
+	// 错误预算的统计窗口，默认28天
+	Window time.Duration `json:"window"`
+	// 燃烧率告警规则，默认采用Google SRE推荐的多窗口多燃烧率规则
+	BurnRateRules []BurnRateRule `json:"burnRateRules,omitempty"`
+}
+
+// 多窗口燃烧率告警规则，长窗口和短窗口的燃烧率同时达到BurnRate时触发告警
+// 长窗口保证告警的显著性，短窗口保证问题消失后告警能够及时恢复
+type BurnRateRule struct {
+	// 长窗口
+	LongWindow time.Duration `json:"longWindow"`
+	// 短窗口，通常为长窗口的1/12
+	ShortWindow time.Duration `json:"shortWindow"`
+	// 燃烧率阈值，1表示恰好在预算窗口结束时耗尽错误预算
+	BurnRate float64 `json:"burnRate"`
+	// 告警级别，默认为严重
+	Severity Severity `json:"severity"`
+}
+
+// 默认的燃烧率告警规则，前两条消耗预算较快，作为严重告警，后两条则用于发现缓慢的预算消耗，作为警告
+var defaultBurnRateRules = []BurnRateRule {
+	{LongWindow: time.Hour, ShortWindow: 5 * time.Minute, BurnRate: 14.4, Severity: CRITICAL},
+	{LongWindow: 6 * time.Hour, ShortWindow: 30 * time.Minute, BurnRate: 6, Severity: CRITICAL},
+	{LongWindow: 24 * time.Hour, ShortWindow: 2 * time.Hour, BurnRate: 3, Severity: WARNING},
+	{LongWindow: 72 * time.Hour, ShortWindow: 6 * time.Hour, BurnRate: 1, Severity: WARNING},
+}
+
+// 输出数据中携带的错误预算信息
+type ErrorBudget struct {
+	// 可用性目标的预算情况
+	Availability *BudgetStatus `json:"availability,omitempty"`
+	// 时延目标的预算情况
+	Latency *BudgetStatus `json:"latency,omitempty"`
+}
+
+// 单个SLO目标的预算情况
+type BudgetStatus struct {
+	// 目标值
+	Objective float64 `json:"objective"`
+	// 预算窗口内的实际达标率，窗口内没有数据时为1
+	Actual float64 `json:"actual"`
+	// 剩余错误预算的比例，1表示尚未消耗，小于0表示已经超支
+	Remaining float64 `json:"remaining"`
+	// 各条燃烧率规则的当前状态
+	BurnRates []BurnRateStatus `json:"burnRates"`
+}
+
+// 单条燃烧率规则的当前状态
+type BurnRateStatus struct {
+	// 长窗口，例如"1h"
+	LongWindow string `json:"longWindow"`
+	// 短窗口，例如"5m"
+	ShortWindow string `json:"shortWindow"`
+	// 长窗口燃烧率
+	LongBurnRate float64 `json:"longBurnRate"`
+	// 短窗口燃烧率
+	ShortBurnRate float64 `json:"shortBurnRate"`
+	// 燃烧率阈值
+	Threshold float64 `json:"threshold"`
+	// 是否达到告警条件
+	Firing bool `json:"firing"`
+	// 规则的告警级别
+	Severity Severity `json:"severity"`
+}
+
+// 一个统计周期内用于SLO计算的数据
+type sloSample struct {
+	Time time.Time
+	// 调用总数
+	Count uint64
+	// 失败总数
+	FailCount uint64
+	// 成功总数
+	SuccessCount uint64
+	// 成功但耗时不达标的总数
+	SlowCount uint64
+}
+
+// 条目的SLO跟踪数据，只在统计分析模块中读写
+type sloTracker struct {
+	// 最近的逐周期数据，保留最长燃烧率窗口的长度
+	samples []sloSample
+	// 按小时聚合的数据，保留整个预算窗口的长度，避免长窗口下逐周期存储占用过多内存
+	buckets []sloSample
+}
+
+// 规范化SLO配置，不合法时返回ConfigError
+func normalizeSLOConfig(slo *SLOConfig) (*SLOConfig, error) {
+	if slo == nil {
+		return nil, nil
+	}
+	s := *slo
+	if s.Availability < 0 || s.Availability >= 1 {
+		return nil, &ConfigError {Key: "slo.availability", Message: "SLO目标必须介于0和1之间"}
+	}
+	if s.Latency < 0 || s.Latency >= 1 {
+		return nil, &ConfigError {Key: "slo.latency", Message: "SLO目标必须介于0和1之间"}
+	}
+	if s.Window <= 0 {
+		s.Window = 28 * 24 * time.Hour
+	}
+	if len(s.BurnRateRules) == 0 {
+		s.BurnRateRules = defaultBurnRateRules
+	}
+	rules := make([]BurnRateRule, len(s.BurnRateRules))
+	for i, rule := range s.BurnRateRules {
+		if rule.LongWindow <= 0 || rule.ShortWindow <= 0 || rule.BurnRate <= 0 {
+			return nil, &ConfigError {Key: fmt.Sprintf("slo.burnRateRules[%d]", i), Message: "燃烧率规则的窗口和阈值必须大于0"}
+		}
+		if rule.Severity == NORMAL {
+			rule.Severity = CRITICAL
+		}
+		rules[i] = rule
+	}
+	s.BurnRateRules = rules
+	return &s, nil
+}
+
+// 记录一个统计周期的数据，并淘汰窗口之外的旧数据
+func (t *sloTracker) add(s sloSample, slo *SLOConfig) {
+	t.samples = append(t.samples, s)
+	var keep time.Duration
+	for _, rule := range slo.BurnRateRules {
+		if rule.LongWindow > keep {
+			keep = rule.LongWindow
+		}
+	}
+	i := 0
+	for i < len(t.samples) && !t.samples[i].Time.After(s.Time.Add(-keep)) {
+		i++
+	}
+	t.samples = t.samples[i:]
+
+	bucketTime := s.Time.Truncate(time.Hour)
+	if n := len(t.buckets); n > 0 && t.buckets[n - 1].Time.Equal(bucketTime) {
+		t.buckets[n - 1].merge(s)
+	} else {
+		s.Time = bucketTime
+		t.buckets = append(t.buckets, s)
+	}
+	i = 0
+	for i < len(t.buckets) && !t.buckets[i].Time.After(bucketTime.Add(-slo.Window)) {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// 累加另一个周期的数据
+func (s *sloSample) merge(o sloSample) {
+	s.Count += o.Count
+	s.FailCount += o.FailCount
+	s.SuccessCount += o.SuccessCount
+	s.SlowCount += o.SlowCount
+}
+
+// 汇总最近一段时间内的逐周期数据
+func (t *sloTracker) sum(now time.Time, d time.Duration) sloSample {
+	total := sloSample {}
+	for i := len(t.samples) - 1; i >= 0 && t.samples[i].Time.After(now.Add(-d)); i-- {
+		total.merge(t.samples[i])
+	}
+	return total
+}
+
+// 汇总整个预算窗口内的数据
+func (t *sloTracker) total() sloSample {
+	total := sloSample {}
+	for _, b := range t.buckets {
+		total.merge(b)
+	}
+	return total
+}
+
+// 计算当前的错误预算
+func (t *sloTracker) budget(now time.Time, slo *SLOConfig) *ErrorBudget {
+	budget := &ErrorBudget {}
+	total := t.total()
+	if slo.Availability > 0 {
+		budget.Availability = t.budgetStatus(now, slo, slo.Availability, total, func(s sloSample) (uint64, uint64) {
+			return s.FailCount, s.Count
+		})
+	}
+	if slo.Latency > 0 {
+		budget.Latency = t.budgetStatus(now, slo, slo.Latency, total, func(s sloSample) (uint64, uint64) {
+			return s.SlowCount, s.SuccessCount
+		})
+	}
+	return budget
+}
+
+// 根据取数函数计算某个目标的预算情况，取数函数返回不达标数与总数
+func (t *sloTracker) budgetStatus(now time.Time, slo *SLOConfig, objective float64, total sloSample, pick func(s sloSample) (uint64, uint64)) *BudgetStatus {
+	status := &BudgetStatus {
+		Objective: objective,
+		Actual: 1,
+		Remaining: 1,
+		BurnRates: make([]BurnRateStatus, 0, len(slo.BurnRateRules)),
+	}
+	bad, count := pick(total)
+	if count > 0 {
+		status.Actual = 1 - float64(bad) / float64(count)
+		status.Remaining = 1 - float64(bad) / (float64(count) * (1 - objective))
+	}
+	burnRate := func(d time.Duration) float64 {
+		bad, count := pick(t.sum(now, d))
+		if count == 0 {
+			return 0
+		}
+		return float64(bad) / float64(count) / (1 - objective)
+	}
+	for _, rule := range slo.BurnRateRules {
+		r := BurnRateStatus {
+			LongWindow: formatWindow(rule.LongWindow),
+			ShortWindow: formatWindow(rule.ShortWindow),
+			LongBurnRate: burnRate(rule.LongWindow),
+			ShortBurnRate: burnRate(rule.ShortWindow),
+			Threshold: rule.BurnRate,
+			Severity: rule.Severity,
+		}
+		r.Firing = r.LongBurnRate >= rule.BurnRate && r.ShortBurnRate >= rule.BurnRate
+		status.BurnRates = append(status.BurnRates, r)
+	}
+	return status
+}
+
+// 达到告警条件的燃烧率规则中最高的告警级别
+func (s *BudgetStatus) burningLevel() Severity {
+	level := NORMAL
+	if s == nil {
+		return level
+	}
+	for _, r := range s.BurnRates {
+		if r.Firing && r.Severity > level {
+			level = r.Severity
+		}
+	}
+	return level
+}
+
+// 将窗口格式化为更易读的形式，例如"5m"、"6h"、"3d"
+func formatWindow(d time.Duration) string {
+	switch {
+	case d >= 24 * time.Hour && d % (24 * time.Hour) == 0:
+		return strconv.FormatInt(int64(d / (24 * time.Hour)), 10) + "d"
+	case d >= time.Hour && d % time.Hour == 0:
+		return strconv.FormatInt(int64(d / time.Hour), 10) + "h"
+	case d >= time.Minute && d % time.Minute == 0:
+		return strconv.FormatInt(int64(d / time.Minute), 10) + "m"
+	case d >= time.Second && d % time.Second == 0:
+		return strconv.FormatInt(int64(d / time.Second), 10) + "s"
+	}
+	return d.String()
+}
@@ -0,0 +1,64 @@
+package monitor
+
+import "sort"
+
+// 快照任务的数据，收集模块通过reply返回当前周期的数据
+type snapshotData struct {
+	reply chan []reportData
+}
+
+// 当前周期截至目前的统计数据，包括汇总条目，按条目名称排列
+// 收集数据只在收集模块中读写，因此通过任务队列请求一份一致的拷贝，Timestamp为快照的时间，客户端关闭之后返回空
+func (c *ReportClientConfig) Snapshot() []OutPutData {
+	reply := make(chan []reportData, 1)
+	var collectedDataList []reportData
+	select {
+	case c.taskChannel <- &taskQueue {
+		taskType: SNAPSHOT,
+		data: snapshotData {reply: reply},
+	}:
+	case <-c.done:
+	}
+	// 任务可能在收集模块退出之前未被处理
+	select {
+	case collectedDataList = <-reply:
+	case <-c.done:
+	}
+	snapshot := make([]OutPutData, 0, len(collectedDataList))
+	for i := range collectedDataList {
+		collectedData := &collectedDataList[i]
+		outputData := OutPutData {
+			ClientName: c.Name,
+			InterfaceName: collectedData.Name,
+			Count: collectedData.FailCount + collectedData.SuccessCount,
+			Timestamp: collectedData.Time.UTC(),
+		}
+		if outputData.Count > 0 {
+			c.fillOutputData(&outputData, collectedData)
+		}
+		snapshot = append(snapshot, outputData)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].InterfaceName < snapshot[j].InterfaceName
+	})
+	return snapshot
+}
+
+// 快照任务，拷贝当前周期的全部收集数据，只在收集模块中调用
+func (c *ReportClientConfig) snapshotTask(curSnapshotData *snapshotData) {
+	now := c.Clock.Now()
+	collectedDataList := c.rollupData(now)
+	for _, curCollectData := range c.collectDataMap {
+		collectedData := *curCollectData
+		collectedData.Time = now
+		collectedData.thresholds = c.cycleThresholds
+		// 收集数据在快照之后仍会被修改，需要深拷贝
+		collectedData.FailDistribution = make(map[int]uint32, len(curCollectData.FailDistribution))
+		for code, count := range curCollectData.FailDistribution {
+			collectedData.FailDistribution[code] = count
+		}
+		collectedData.TimeConsumingDistribution = append([]uint32 {}, curCollectData.TimeConsumingDistribution...)
+		collectedDataList = append(collectedDataList, collectedData)
+	}
+	curSnapshotData.reply <- collectedDataList
+}
@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 告警状态的持久化存储，用于在重启之后恢复告警中的状态，避免重启期间丢失恢复通知
+type StateStore interface {
+	// 保存客户端的告警状态
+	Save(clientName string, state *ClientState) error
+	// 读取客户端的告警状态，不存在时返回nil
+	Load(clientName string) (*ClientState, error)
+}
+
+// 客户端的告警状态快照
+type ClientState struct {
+	// 保存时间
+	SavedAt time.Time `json:"savedAt"`
+	// 每个条目每种告警类型的状态
+	Entries []EntryState `json:"entries"`
+}
+
+// 条目某种告警类型的状态
+type EntryState struct {
+	// 接口命名
+	InterfaceName string `json:"interfaceName"`
+	// 告警类型
+	AlertType AlertType `json:"alertType"`
+	// 当前的告警状态，NONE表示未处于告警中
+	CurState AlertType `json:"curState"`
+	// 当前告警的级别
+	Severity Severity `json:"severity"`
+	// 最近一次级别变化之前的级别
+	PrevSeverity Severity `json:"prevSeverity"`
+	// 当前告警是否已经发出通知
+	Notified bool `json:"notified"`
+	// 当前告警是否已经升级
+	Escalated bool `json:"escalated"`
+	// 当前告警的开始时间
+	StartsAt time.Time `json:"startsAt"`
+	// 最近一次发出告警通知的时间
+	NotifiedAt time.Time `json:"notifiedAt"`
+	// 连续不达标的数据
+	RecentAlertOutput []OutPutData `json:"recentAlertOutput"`
+	// 告警之后连续达标的数据
+	RecentRecoverOutput []OutPutData `json:"recentRecoverOutput"`
+	// 滑动窗口模式下，最近各周期是否满足告警或恢复的条件
+	RecentHits []bool `json:"recentHits,omitempty"`
+	// 触发告警时的数据
+	FiringOutput []OutPutData `json:"firingOutput"`
+	// 抖动检测窗口内的状态变化时间
+	Transitions []time.Time `json:"transitions,omitempty"`
+	// 是否处于抖动中
+	Flapping bool `json:"flapping,omitempty"`
+}
+
+// 基于文件的告警状态存储，每个客户端一个json文件
+type FileStateStore struct {
+	// 存放状态文件的目录
+	Dir string
+}
+
+// 创建基于文件的告警状态存储
+func NewFileStateStore(dir string) *FileStateStore {
+	return &FileStateStore {Dir: dir}
+}
+
+// 客户端状态文件的路径，客户端名称转义后作为文件名
+func (s *FileStateStore) path(clientName string) string {
+	return filepath.Join(s.Dir, url.PathEscape(clientName) + ".json")
+}
+
+// 保存客户端的告警状态，先写临时文件再重命名，避免写到一半时进程退出造成文件损坏
+func (s *FileStateStore) Save(clientName string, state *ClientState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	tmp := s.path(clientName) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(clientName))
+}
+
+// 读取客户端的告警状态
+func (s *FileStateStore) Load(clientName string) (*ClientState, error) {
+	b, err := os.ReadFile(s.path(clientName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := &ClientState {}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// 生成当前的告警状态快照，只在告警分析模块中调用
+func (c *ReportClientConfig) snapshotState(now time.Time) *ClientState {
+	state := &ClientState {SavedAt: now, Entries: []EntryState {}}
+	for alertType, statusMap := range c.alertStatusMap {
+		for entryName, status := range statusMap {
+			state.Entries = append(state.Entries, EntryState {
+				InterfaceName: entryName,
+				AlertType: alertType,
+				CurState: status.curState,
+				Severity: status.severity,
+				PrevSeverity: status.prevSeverity,
+				Notified: status.notified,
+				Escalated: status.escalated,
+				StartsAt: status.startsAt,
+				NotifiedAt: status.notifiedAt,
+				RecentAlertOutput: append([]OutPutData {}, status.recentAlertOutput...),
+				RecentRecoverOutput: append([]OutPutData {}, status.recentRecoverOutput...),
+				RecentHits: append([]bool {}, status.recentHits...),
+				Transitions: append([]time.Time {}, status.transitions...),
+				Flapping: status.flapping,
+				FiringOutput: append([]OutPutData {}, status.firingOutput...),
+			})
+		}
+	}
+	// 保持稳定的顺序，便于对比快照
+	sort.Slice(state.Entries, func(i, j int) bool {
+		if state.Entries[i].InterfaceName != state.Entries[j].InterfaceName {
+			return state.Entries[i].InterfaceName < state.Entries[j].InterfaceName
+		}
+		return state.Entries[i].AlertType < state.Entries[j].AlertType
+	})
+	return state
+}
+
+// 保存告警状态，出错时输出到控制台
+func (c *ReportClientConfig) saveState(now time.Time) {
+	if err := c.StateStore.Save(c.Name, c.snapshotState(now)); err != nil {
+		os.Stderr.WriteString("保存告警状态失败：" + err.Error() + "\n")
+	}
+}
+
+// 恢复告警状态，超过StateMaxAge的状态视为过期而丢弃，只在注册时调用
+func (c *ReportClientConfig) restoreState() {
+	state, err := c.StateStore.Load(c.Name)
+	if err != nil {
+		os.Stderr.WriteString("读取告警状态失败：" + err.Error() + "\n")
+		return
+	}
+	if state == nil || c.Clock.Now().Sub(state.SavedAt) > c.StateMaxAge {
+		return
+	}
+	for _, e := range state.Entries {
+		status := c.getAlertStatus(e.AlertType, e.InterfaceName)
+		status.curState = e.CurState
+		status.severity = e.Severity
+		status.prevSeverity = e.PrevSeverity
+		status.notified = e.Notified
+		status.escalated = e.Escalated
+		status.startsAt = e.StartsAt
+		status.notifiedAt = e.NotifiedAt
+		status.recentAlertOutput = append(status.recentAlertOutput, e.RecentAlertOutput...)
+		status.recentRecoverOutput = e.RecentRecoverOutput
+		status.recentHits = e.RecentHits
+		status.transitions = e.Transitions
+		status.flapping = e.Flapping
+		status.firingOutput = e.FiringOutput
+		c.publishAlerts(e.InterfaceName)
+	}
+}
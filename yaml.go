@@ -0,0 +1,377 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 配置文件支持的YAML子集，转为JSON之后沿用JSON配置的解析与校验
+// 支持以缩进表示的映射与序列、行内的[]与{}、单双引号字符串与#注释，不支持锚点、标签、多文档与多行字符串
+type yamlLine struct {
+	// 行号，从1开始
+	number int
+	// 缩进的空格数
+	indent int
+	// 去掉缩进与注释之后的内容
+	text string
+}
+
+// 按行解析YAML
+type yamlParser struct {
+	lines []yamlLine
+	pos int
+}
+
+// YAML格式的错误
+type yamlError struct {
+	line int
+	message string
+}
+
+// YAML配置转为JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	lines, err := yamlLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return []byte("{}"), nil
+	}
+	p := &yamlParser {lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err == nil && p.pos < len(lines) {
+		err = &yamlError {line: lines[p.pos].number, message: "缩进不一致"}
+	}
+	if err != nil {
+		e := err.(*yamlError)
+		return nil, &ConfigError {Message: fmt.Sprintf("第%d行格式错误：%s", e.line, e.message)}
+	}
+	return json.Marshal(v)
+}
+
+// 错误信息
+func (e *yamlError) Error() string {
+	return e.message
+}
+
+// 拆分为有内容的行，去掉注释、空行与文档开始的标记
+func yamlLines(data string) ([]yamlLine, error) {
+	lines := []yamlLine {}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripYAMLComment(strings.TrimRight(line, "\r")), " \t")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" && len(lines) == 0 {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, &ConfigError {Message: fmt.Sprintf("第%d行格式错误：%s", i + 1, "不能使用制表符缩进")}
+		}
+		lines = append(lines, yamlLine {number: i + 1, indent: len(line) - len(text), text: text})
+	}
+	return lines, nil
+}
+
+// 去掉注释，引号中的#不是注释
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote == '"' && line[i] == '\\':
+			i++
+		case quote != 0:
+			if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '#' && (i == 0 || line[i - 1] == ' ' || line[i - 1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// 是否为序列的一项
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// 解析缩进为indent的一块内容，序列或映射
+func (p *yamlParser) block(indent int) (interface {}, error) {
+	if isYAMLSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// 解析序列
+func (p *yamlParser) sequence(indent int) (interface {}, error) {
+	list := []interface {} {}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || line.indent == indent && !isYAMLSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent || !isYAMLSequenceItem(line.text) {
+			return nil, &yamlError {line: line.number, message: "缩进不一致"}
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		var item interface {}
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.block(p.lines[p.pos].indent)
+			}
+		case isYAMLSequenceItem(rest) || isYAMLMapping(rest):
+			// 该项的内容从"-"之后开始，作为一行缩进更深的内容解析
+			column := line.indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine {number: line.number, indent: column, text: rest}
+			item, err = p.block(column)
+		default:
+			p.pos++
+			item, err = yamlValue(rest, line.number)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// 解析映射
+func (p *yamlParser) mapping(indent int) (interface {}, error) {
+	m := map[string]interface {} {}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || line.indent == indent && isYAMLSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, &yamlError {line: line.number, message: "缩进不一致"}
+		}
+		key, rest, err := splitYAMLMapping(line.text, line.number)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[key]; ok {
+			return nil, &yamlError {line: line.number, message: "重复的键：" + key}
+		}
+		p.pos++
+		var value interface {}
+		if rest != "" {
+			value, err = yamlValue(rest, line.number)
+		} else if p.pos < len(p.lines) {
+			// 值在之后缩进更深的行中，序列可以与键保持相同的缩进
+			next := p.lines[p.pos]
+			if next.indent > indent || next.indent == indent && isYAMLSequenceItem(next.text) {
+				value, err = p.block(next.indent)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// 是否为"键: 值"的形式
+func isYAMLMapping(text string) bool {
+	if text[0] == '[' || text[0] == '{' {
+		return false
+	}
+	_, _, err := splitYAMLMapping(text, 0)
+	return err == nil
+}
+
+// 拆分"键: 值"，键可以使用引号
+func splitYAMLMapping(text string, number int) (key string, rest string, err error) {
+	end := -1
+	if text[0] == '"' || text[0] == '\'' {
+		f := &yamlFlow {s: text, line: number}
+		v, err := f.quoted()
+		if err != nil {
+			return "", "", err
+		}
+		key = v.(string)
+		if strings.HasPrefix(text[f.i:], ":") {
+			end = f.i
+		}
+	} else {
+		for i := 0; i < len(text); i++ {
+			if text[i] == ':' && (i == len(text) - 1 || text[i + 1] == ' ') {
+				end = i
+				key = strings.TrimRight(text[:i], " ")
+				break
+			}
+		}
+	}
+	if end < 0 || end + 1 < len(text) && text[end + 1] != ' ' {
+		return "", "", &yamlError {line: number, message: "应为\"键: 值\"的形式：" + text}
+	}
+	return key, strings.TrimLeft(text[end + 1:], " "), nil
+}
+
+// 解析一行中的值，行内的序列与映射或标量
+func yamlValue(text string, number int) (interface {}, error) {
+	switch text[0] {
+	case '|', '>', '&', '*', '!':
+		return nil, &yamlError {line: number, message: "不支持的语法：" + text}
+	}
+	f := &yamlFlow {s: text, line: number}
+	v, err := f.value("")
+	if err != nil {
+		return nil, err
+	}
+	if f.skip(); f.i < len(f.s) {
+		return nil, &yamlError {line: number, message: "多余的内容：" + f.s[f.i:]}
+	}
+	return v, nil
+}
+
+// 解析一行中的内容
+type yamlFlow struct {
+	s string
+	i int
+	line int
+}
+
+// 跳过空格
+func (f *yamlFlow) skip() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// 解析一个值，未加引号的标量在stop中的字符处结束
+func (f *yamlFlow) value(stop string) (interface {}, error) {
+	f.skip()
+	if f.i >= len(f.s) {
+		return nil, nil
+	}
+	switch f.s[f.i] {
+	case '[':
+		return f.sequence()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		return f.quoted()
+	}
+	start := f.i
+	for f.i < len(f.s) && !strings.ContainsRune(stop, rune(f.s[f.i])) {
+		f.i++
+	}
+	return yamlScalar(strings.TrimRight(f.s[start:f.i], " ")), nil
+}
+
+// 解析行内的序列
+func (f *yamlFlow) sequence() (interface {}, error) {
+	f.i++
+	list := []interface {} {}
+	for {
+		if f.skip(); f.i < len(f.s) && f.s[f.i] == ']' && len(list) == 0 {
+			f.i++
+			return list, nil
+		}
+		v, err := f.value(",]")
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if err := f.next(']'); err != nil {
+			return nil, err
+		}
+		if f.s[f.i - 1] == ']' {
+			return list, nil
+		}
+	}
+}
+
+// 解析行内的映射
+func (f *yamlFlow) mapping() (interface {}, error) {
+	f.i++
+	m := map[string]interface {} {}
+	for {
+		if f.skip(); f.i < len(f.s) && f.s[f.i] == '}' && len(m) == 0 {
+			f.i++
+			return m, nil
+		}
+		k, err := f.value(":,}")
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if f.skip(); !ok || f.i >= len(f.s) || f.s[f.i] != ':' {
+			return nil, &yamlError {line: f.line, message: "应为\"键: 值\"的形式：" + f.s}
+		}
+		f.i++
+		if m[key], err = f.value(",}"); err != nil {
+			return nil, err
+		}
+		if err := f.next('}'); err != nil {
+			return nil, err
+		}
+		if f.s[f.i - 1] == '}' {
+			return m, nil
+		}
+	}
+}
+
+// 读取分隔的逗号或结束的括号
+func (f *yamlFlow) next(end byte) error {
+	if f.skip(); f.i < len(f.s) && (f.s[f.i] == ',' || f.s[f.i] == end) {
+		f.i++
+		return nil
+	}
+	return &yamlError {line: f.line, message: "缺少" + string(end) + "：" + f.s}
+}
+
+// 解析引号中的字符串，双引号的转义同JSON，单引号中以两个单引号表示一个单引号
+func (f *yamlFlow) quoted() (interface {}, error) {
+	quote := f.s[f.i]
+	for i := f.i + 1; i < len(f.s); i++ {
+		if quote == '"' && f.s[i] == '\\' {
+			i++
+			continue
+		}
+		if f.s[i] != quote {
+			continue
+		}
+		if quote == '\'' && i + 1 < len(f.s) && f.s[i + 1] == '\'' {
+			i++
+			continue
+		}
+		s := f.s[f.i:i + 1]
+		f.i = i + 1
+		if quote == '\'' {
+			return strings.ReplaceAll(s[1:len(s) - 1], "''", "'"), nil
+		}
+		var v string
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, &yamlError {line: f.line, message: "字符串格式错误：" + s}
+		}
+		return v, nil
+	}
+	return nil, &yamlError {line: f.line, message: "缺少结束的引号：" + f.s[f.i:]}
+}
+
+// 未加引号的标量，依次尝试null、布尔值与数字，否则为字符串
+func yamlScalar(s string) interface {} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	var number float64
+	if err := json.Unmarshal([]byte(s), &number); err == nil {
+		return number
+	}
+	return s
+}